@@ -7,7 +7,6 @@ import (
 	"git.sr.ht/~hamcha/containers/sync"
 	"github.com/nicklaw5/helix/v2"
 	"github.com/urfave/cli/v2"
-	"github.com/wailsapp/wails/v2/pkg/runtime"
 	"go.uber.org/zap"
 
 	"github.com/strimertul/strimertul/database"
@@ -22,8 +21,10 @@ type App struct {
 	cliParams *cli.Context
 	driver    database.DatabaseDriver
 	ready     *sync.RWSync[bool]
+	headless  bool // Running without a window (serve command), there is no UI to send events to
 
 	db             *database.LocalDBClient
+	stopConfigSync func()
 	twitchManager  *twitch.Manager
 	httpServer     *http.Server
 	loyaltyManager *loyalty.Manager
@@ -46,28 +47,49 @@ func (a *App) startup(ctx context.Context) {
 	a.driver, err = database.GetDatabaseDriver(a.cliParams)
 	failOnError(err, "error opening database")
 
+	hub := a.driver.Hub()
+	go hub.Run()
+
+	a.db, err = database.NewLocalClient(hub, a.driver.Secrets(), logger)
+	failOnError(err, "failed to initialize database module")
+
+	backupOpts := getBackupOptions(a.cliParams)
+	// Bring stored data up to date before any module reads it
+	err = database.RunMigrations(a.driver, a.db, backupOpts, logger)
+	failOnError(err, "could not migrate database")
+
+	// Apply the config file before modules read their configuration
+	if path := a.cliParams.String("config"); path != "" {
+		config, err := LoadConfigFile(path)
+		failOnError(err, "could not read config file")
+		mode := a.cliParams.String("config-mode")
+		failOnError(config.Apply(a.db, mode), "could not apply config file")
+		if mode == configModeSync {
+			a.stopConfigSync, err = config.Sync(a.db)
+			failOnError(err, "could not keep config file in sync")
+		}
+	}
+
+	// Let kilovolt clients use atomic operations
+	err = a.db.ServeAtomicRPC()
+	failOnError(err, "could not set up database RPC")
+
 	// Start database backup task
-	backupOpts := database.BackupOptions{
-		BackupDir:      a.cliParams.String("backup-dir"),
-		BackupInterval: a.cliParams.Int("backup-interval"),
-		MaxBackups:     a.cliParams.Int("max-backups"),
+	// In-memory databases are meant to be thrown away, don't leave backups behind
+	if _, ephemeral := a.driver.(*database.MemoryDatabase); ephemeral {
+		backupOpts.BackupInterval = 0
 	}
 	if backupOpts.BackupInterval > 0 {
 		go BackupTask(a.driver, backupOpts)
 	}
 
-	hub := a.driver.Hub()
-	go hub.Run()
-
-	a.db, err = database.NewLocalClient(hub, logger)
-	failOnError(err, "failed to initialize database module")
-
 	// Set meta keys
 	_ = a.db.PutKey("stul-meta/version", appVersion)
 
 	// Create logger and endpoints
 	a.httpServer, err = http.NewServer(a.db, logger)
 	failOnError(err, "could not initialize http server")
+	a.httpServer.SetCertificateDir(a.cliParams.String("database-dir"))
 
 	// Create twitch client
 	a.twitchManager, err = twitch.NewManager(a.db, a.httpServer, logger)
@@ -78,13 +100,13 @@ func (a *App) startup(ctx context.Context) {
 	failOnError(err, "could not initialize loyalty manager")
 
 	a.ready.Set(true)
-	runtime.EventsEmit(ctx, "ready", true)
+	a.emit("ready", true)
 	logger.Info("app is ready")
 
 	// Start redirecting logs to UI
 	go func() {
 		for entry := range incomingLogs {
-			runtime.EventsEmit(ctx, "log-event", entry)
+			a.emit("log-event", entry)
 		}
 	}()
 
@@ -92,7 +114,18 @@ func (a *App) startup(ctx context.Context) {
 	failOnError(a.httpServer.Listen(), "HTTP server stopped")
 }
 
+// emit sends an event to the UI, if there is one
+func (a *App) emit(event string, data interface{}) {
+	if a.headless {
+		return
+	}
+	emitUIEvent(a.ctx, event, data)
+}
+
 func (a *App) stop(context.Context) {
+	if a.stopConfigSync != nil {
+		a.stopConfigSync()
+	}
 	if a.loyaltyManager != nil {
 		warnOnError(a.loyaltyManager.Close(), "could not cleanly close loyalty manager")
 	}
@@ -102,9 +135,12 @@ func (a *App) stop(context.Context) {
 	if a.httpServer != nil {
 		warnOnError(a.httpServer.Close(), "could not cleanly close HTTP server")
 	}
-	warnOnError(a.db.Close(), "could not cleanly close database")
-
-	warnOnError(a.driver.Close(), "could not close driver")
+	if a.db != nil {
+		warnOnError(a.db.Close(), "could not cleanly close database")
+	}
+	if a.driver != nil {
+		warnOnError(a.driver.Close(), "could not close driver")
+	}
 }
 
 func (a *App) AuthenticateKVClient(id string) {
@@ -134,6 +170,42 @@ func (a *App) GetTwitchLoggedUser() (helix.User, error) {
 	return a.twitchManager.Client().GetLoggedUser()
 }
 
+// GetSecret returns the value of a key with its secrets in clear, kilovolt clients only get them masked
+func (a *App) GetSecret(key string) (string, error) {
+	return a.db.GetSecret(key)
+}
+
+// GetLogTail returns the data of the last count entries of the event log stored under prefix, oldest first
+func (a *App) GetLogTail(prefix string, count int) ([]string, error) {
+	log, err := database.NewEventLog(a.db, prefix, database.RetentionPolicy{})
+	if err != nil {
+		return nil, err
+	}
+	entries, err := log.Tail(count)
+	if err != nil {
+		return nil, err
+	}
+	out := make([]string, len(entries))
+	for index, entry := range entries {
+		out[index] = entry.Data
+	}
+	return out, nil
+}
+
+// GetDatabaseStats returns key counts and sizes per prefix, the largest keys and storage engine metrics
+func (a *App) GetDatabaseStats() (database.DatabaseStats, error) {
+	return database.CollectStats(a.driver, database.StatsOptions{
+		Depth:       2,
+		LargestKeys: 10,
+		BackupDir:   a.cliParams.String("backup-dir"),
+	})
+}
+
+// GetEnvOverrides returns the config fields set by environment variables, which can't be changed from the dashboard
+func (a *App) GetEnvOverrides() []database.EnvOverride {
+	return database.ActiveEnvOverrides()
+}
+
 func (a *App) GetLastLogs() []LogEntry {
 	return lastLogs.Get()
 }
@@ -1,9 +1,10 @@
 package main
 
 import (
-	"fmt"
 	"os"
+	"path/filepath"
 	"sort"
+	"sync"
 	"time"
 
 	"go.uber.org/zap"
@@ -26,39 +27,154 @@ func BackupTask(driver database.DatabaseDriver, options database.BackupOptions)
 		return
 	}
 
+	// Keep track of changed keys for incremental backups
+	changes := newChangeTracker()
+	if options.FullBackupEvery > 1 {
+		defer driver.Watch(changes.Record)()
+	}
+
+	// The first backup is always a full one, since we don't know what changed while we weren't running
+	lastFull := ""
+	sequence := 0
+
 	ticker := time.NewTicker(time.Duration(options.BackupInterval) * time.Minute)
 	defer ticker.Stop()
 	for range ticker.C {
+		// Start a new chain if the full backup we were building upon got removed
+		if lastFull != "" {
+			if _, err := os.Stat(filepath.Join(options.BackupDir, lastFull)); err != nil {
+				lastFull = ""
+			}
+		}
+		full := lastFull == "" || options.FullBackupEvery <= 1 || sequence+1 >= options.FullBackupEvery
+		changed := changes.Take()
+
 		// Run backup procedure
-		file, err := os.Create(fmt.Sprintf("%s/%s.db", options.BackupDir, time.Now().Format("20060102-150405")))
+		name := time.Now().Format("20060102-150405")
+		if full {
+			name += ".db"
+		} else {
+			name += ".inc.db"
+		}
+		file, err := os.Create(filepath.Join(options.BackupDir, name))
 		if err != nil {
 			logger.Error("could not create backup file", zap.Error(err))
+			changes.Merge(changed)
 			continue
 		}
-		err = driver.Backup(file)
+		var header database.BackupHeader
+		if full {
+			header, err = database.WriteBackupArchive(file, driver, options.DriverName, options.Passphrase)
+		} else {
+			header, err = database.WriteIncrementalArchive(file, driver, options.DriverName, lastFull, sequence+1, changed, options.Passphrase)
+		}
+		_ = file.Close()
 		if err != nil {
 			logger.Error("could not backup database", zap.Error(err))
+			_ = os.Remove(file.Name())
+			changes.Merge(changed)
+			continue
 		}
-		_ = file.Close()
-		logger.Info("database backed up", zap.String("backup-file", file.Name()))
-		// Remove old backups
-		files, err := os.ReadDir(options.BackupDir)
+		if full {
+			lastFull = name
+			sequence = 0
+		} else {
+			sequence++
+		}
+		logger.Info("database backed up", zap.String("backup-file", file.Name()), zap.String("kind", header.Kind), zap.Int("keys", header.Keys))
+
+		pruneBackups(options)
+	}
+}
+
+// pruneBackups removes the oldest backups when over the limit. The limit counts chains (a full backup along with the
+// incremental backups built upon it) rather than files, so there are always as many restore points as configured and
+// a chain is only ever removed as a whole. Incremental backups left without their full backup are removed too.
+func pruneBackups(options database.BackupOptions) {
+	files, err := os.ReadDir(options.BackupDir)
+	if err != nil {
+		logger.Error("could not read backup directory", zap.Error(err))
+		return
+	}
+	// Sort by date
+	sort.Sort(utils.ByDate(files))
+
+	// Group incremental backups by the full backup they build upon
+	var chains []string
+	increments := make(map[string][]string)
+	for _, file := range files {
+		if file.IsDir() {
+			continue
+		}
+		info, err := database.ReadBackupInfo(filepath.Join(options.BackupDir, file.Name()))
+		if err == nil && info.Header.Incremental() {
+			increments[info.Header.Base] = append(increments[info.Header.Base], file.Name())
+			continue
+		}
+		// Full backups, including the ones in the old format
+		chains = append(chains, file.Name())
+	}
+
+	remove := func(name string) {
+		err := os.Remove(filepath.Join(options.BackupDir, name))
 		if err != nil {
-			logger.Error("could not read backup directory", zap.Error(err))
+			logger.Error("could not remove backup file", zap.Error(err))
+		}
+	}
+
+	// If maxBackups is set, remove older chains when we reach the limit
+	if options.MaxBackups > 0 && len(chains) > options.MaxBackups {
+		for _, name := range chains[:len(chains)-options.MaxBackups] {
+			remove(name)
+		}
+		chains = chains[len(chains)-options.MaxBackups:]
+	}
+
+	// Incremental backups can't be restored without their full backup
+	kept := make(map[string]bool)
+	for _, name := range chains {
+		kept[name] = true
+	}
+	for base, names := range increments {
+		if kept[base] {
 			continue
 		}
-		// If maxBackups is set, remove older backups when we reach the limit
-		if options.MaxBackups > 0 && len(files) > options.MaxBackups {
-			// Sort by date
-			sort.Sort(utils.ByDate(files))
-			// Get files to remove
-			toRemove := files[:len(files)-options.MaxBackups]
-			for _, file := range toRemove {
-				err = os.Remove(fmt.Sprintf("%s/%s", options.BackupDir, file.Name()))
-				if err != nil {
-					logger.Error("could not remove backup file", zap.Error(err))
-				}
-			}
+		for _, name := range names {
+			remove(name)
 		}
 	}
 }
+
+// changeTracker collects the keys that were modified since the last backup
+type changeTracker struct {
+	mu      sync.Mutex
+	changed map[string]bool
+}
+
+func newChangeTracker() *changeTracker {
+	return &changeTracker{changed: make(map[string]bool)}
+}
+
+func (c *changeTracker) Record(key string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.changed[key] = true
+}
+
+// Take returns the changed keys and starts tracking from scratch
+func (c *changeTracker) Take() map[string]bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	changed := c.changed
+	c.changed = make(map[string]bool)
+	return changed
+}
+
+// Merge adds back keys from a backup that failed, so they are included in the next one
+func (c *changeTracker) Merge(keys map[string]bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for key := range keys {
+		c.changed[key] = true
+	}
+}
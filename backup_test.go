@@ -0,0 +1,145 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/strimertul/strimertul/database"
+)
+
+func TestPruneBackupsKeepsChains(t *testing.T) {
+	logger = zap.NewNop()
+	directory := t.TempDir()
+	driver, err := database.NewInMemory(logger)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer driver.Close()
+
+	// Two chains of a full backup and two increments each, plus an increment without its full backup
+	now := time.Now()
+	backups := []struct {
+		name string
+		base string
+	}{
+		{"1.db", ""}, {"1-1.inc.db", "1.db"}, {"1-2.inc.db", "1.db"},
+		{"2.db", ""}, {"2-1.inc.db", "2.db"}, {"2-2.inc.db", "2.db"},
+		{"0-1.inc.db", "0.db"},
+	}
+	for index, backup := range backups {
+		path := filepath.Join(directory, backup.name)
+		file, err := os.Create(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if backup.base == "" {
+			_, err = database.WriteBackupArchive(file, driver, "memory", "")
+		} else {
+			_, err = database.WriteIncrementalArchive(file, driver, "memory", backup.base, 1, map[string]bool{}, "")
+		}
+		_ = file.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		modTime := now.Add(time.Duration(index) * time.Minute)
+		if err = os.Chtimes(path, modTime, modTime); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		maxBackups int
+		expected   []string
+	}{
+		// Orphaned increments go even with no limit
+		{0, []string{"1-1.inc.db", "1-2.inc.db", "1.db", "2-1.inc.db", "2-2.inc.db", "2.db"}},
+		// Two chains fit, even if that's more than two files
+		{2, []string{"1-1.inc.db", "1-2.inc.db", "1.db", "2-1.inc.db", "2-2.inc.db", "2.db"}},
+		// The oldest chain goes as a whole
+		{1, []string{"2-1.inc.db", "2-2.inc.db", "2.db"}},
+	}
+	for _, test := range tests {
+		pruneBackups(database.BackupOptions{BackupDir: directory, MaxBackups: test.maxBackups})
+
+		entries, err := os.ReadDir(directory)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var names []string
+		for _, entry := range entries {
+			names = append(names, entry.Name())
+		}
+		sort.Strings(names)
+		if len(names) != len(test.expected) {
+			t.Fatalf("with max %d: kept %v, expected %v", test.maxBackups, names, test.expected)
+		}
+		for index := range names {
+			if names[index] != test.expected[index] {
+				t.Fatalf("with max %d: kept %v, expected %v", test.maxBackups, names, test.expected)
+			}
+		}
+	}
+}
+
+func TestChangeTrackerManyWrites(t *testing.T) {
+	logger = zap.NewNop()
+	driver, err := database.NewInMemory(logger)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer driver.Close()
+	go driver.Hub().Run()
+	db, err := database.NewLocalClient(driver.Hub(), driver.Secrets(), logger)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	changes := newChangeTracker()
+	defer driver.Watch(changes.Record)()
+
+	// Tracking changes must not hold up the hub, no matter how many writes there are between backups
+	const writes = 250
+	done := make(chan error, 1)
+	go func() {
+		for i := 0; i < writes; i++ {
+			if err := db.PutKey(fmt.Sprintf("test/key-%d", i), "value"); err != nil {
+				done <- err
+				return
+			}
+		}
+		done <- db.RemoveKey("test/key-0")
+	}()
+	select {
+	case err = <-done:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("writes got stuck")
+	}
+
+	changed := changes.Take()
+	for i := 0; i < writes; i++ {
+		if !changed[fmt.Sprintf("test/key-%d", i)] {
+			t.Fatalf("test/key-%d was not recorded", i)
+		}
+	}
+	header, err := database.WriteIncrementalArchive(io.Discard, driver, "memory", "base.db", 1, changed, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if header.Keys != len(changed) {
+		t.Fatalf("incremental backup has %d keys, expected %d", header.Keys, len(changed))
+	}
+	if len(changes.Take()) != 0 {
+		t.Fatal("changes were not reset")
+	}
+}
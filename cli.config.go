@@ -0,0 +1,81 @@
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/urfave/cli/v2"
+	"gopkg.in/yaml.v3"
+
+	"github.com/strimertul/strimertul/database"
+)
+
+func cliConfigCheck(ctx *cli.Context) error {
+	path := ctx.Args().First()
+	if path == "" {
+		path = ctx.String("config")
+	}
+	if path == "" {
+		return cli.Exit("usage: config check <file> (or set --config)", 64)
+	}
+
+	config, err := LoadConfigFile(path)
+	if err != nil {
+		return fatalError(err, "config file is not valid")
+	}
+	names := sortedSectionNames(config.sections)
+	if len(names) == 0 {
+		fmt.Println("config file is valid but empty")
+		return nil
+	}
+	fmt.Println("config file is valid, sections:")
+	for _, name := range names {
+		section, _ := findConfigSection(name)
+		fmt.Printf("  %s (%s)\n", name, section.Key)
+	}
+	return nil
+}
+
+func cliConfigDump(ctx *cli.Context) error {
+	driver, err := database.GetDatabaseDriver(ctx)
+	if err != nil {
+		return fatalError(err, "could not open database")
+	}
+	defer func() {
+		warnOnError(driver.Close(), "could not close database")
+	}()
+	db, err := startLocalClient(driver)
+	if err != nil {
+		return fatalError(err, "could not initialize database client")
+	}
+	defer func() {
+		warnOnError(db.Close(), "could not close database client")
+	}()
+
+	sections, err := DumpConfig(db, ctx.Bool("include-secrets"))
+	if err != nil {
+		return fatalError(err, "could not read config")
+	}
+
+	if ctx.Bool("json") {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(sections)
+	}
+
+	// Build the document by hand to keep sections in a sensible order, maps are sorted by key
+	document := &yaml.Node{Kind: yaml.MappingNode}
+	for _, name := range sortedSectionNames(sections) {
+		var value yaml.Node
+		if err = value.Encode(sections[name]); err != nil {
+			return fatalError(err, "could not encode config")
+		}
+		document.Content = append(document.Content, &yaml.Node{Kind: yaml.ScalarNode, Value: name}, &value)
+	}
+	encoder := yaml.NewEncoder(os.Stdout)
+	encoder.SetIndent(2)
+	if err = encoder.Encode(document); err != nil {
+		return fatalError(err, "could not encode config")
+	}
+	return encoder.Close()
+}
@@ -1,7 +1,17 @@
 package main
 
 import (
+	"bufio"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
 	"os"
+	"path/filepath"
+	"text/tabwriter"
+	"time"
+
+	"go.uber.org/zap"
 
 	"github.com/strimertul/strimertul/database"
 
@@ -19,18 +29,15 @@ func cliImport(ctx *cli.Context) error {
 		defer file.Close()
 		inStream = file
 	}
-	var entries map[string]string
-	err := json.NewDecoder(inStream).Decode(&entries)
-	if err != nil {
-		return fatalError(err, "could not decode import file")
-	}
-
 	driver, err := database.GetDatabaseDriver(ctx)
 	if err != nil {
 		return fatalError(err, "could not open database")
 	}
+	defer func() {
+		warnOnError(driver.Close(), "could not close database")
+	}()
 
-	err = driver.Import(entries)
+	err = database.ImportFiltered(driver, inStream, keyFilter(ctx))
 	if err != nil {
 		return fatalError(err, "import failed")
 	}
@@ -40,31 +47,243 @@ func cliImport(ctx *cli.Context) error {
 }
 
 func cliRestore(ctx *cli.Context) error {
-	inStream := os.Stdin
-	fileArg := ctx.String("file")
-	if fileArg != "" {
-		file, err := os.Open(fileArg)
+	if ctx.IsSet("at") && ctx.IsSet("file") {
+		return cli.Exit("--at and --file can't be used together", 64)
+	}
+
+	var info database.BackupInfo
+	if ctx.IsSet("at") {
+		at, err := parseBackupTime(ctx.String("at"))
 		if err != nil {
-			return fatalError(err, "could not open import file for reading")
+			return fatalError(err, "invalid time")
 		}
-		defer file.Close()
-		inStream = file
+		info, err = database.FindBackupAt(ctx.String("backup-dir"), at)
+		if err != nil {
+			return fatalError(err, "could not find a backup to restore")
+		}
+		logger.Info("found backup", zap.String("backup-file", info.Path), zap.Time("backup-time", info.Header.Time))
 	}
 
 	driver, err := database.GetDatabaseDriver(ctx)
 	if err != nil {
 		return fatalError(err, "could not open database")
 	}
+	defer func() {
+		warnOnError(driver.Close(), "could not close database")
+	}()
+
+	if info.Path == "" {
+		file, closeFile, err := openBackupFile(ctx.String("file"))
+		if err != nil {
+			return fatalError(err, "could not open backup file for reading")
+		}
+		defer closeFile()
 
-	err = driver.Restore(inStream)
+		reader := bufio.NewReader(file)
+		if !database.IsBackupArchive(reader) {
+			logger.Warn("backup is in the old uncompressed format, it has no checksum to verify its integrity")
+			// Read the whole backup before clearing the database, a broken file must not leave a partial restore
+			keys, err := database.VerifyLegacyBackup(reader)
+			if err != nil {
+				return fatalError(err, "backup failed verification, refusing to restore it")
+			}
+			if _, err = file.Seek(0, io.SeekStart); err != nil {
+				return fatalError(err, "could not read backup file")
+			}
+			// Old backups are always full ones
+			err = driver.Clear()
+			if err != nil {
+				return fatalError(err, "could not clear database")
+			}
+			err = driver.Restore(file)
+			if err != nil {
+				return fatalError(err, "restore failed")
+			}
+			logger.Info("restored database from backup", zap.Int("keys", keys))
+			return nil
+		}
+
+		info, err = database.ReadBackupInfo(file.Name())
+		if err != nil {
+			return fatalError(err, "could not read backup")
+		}
+		if info.Header.Incremental() && ctx.String("file") == "" {
+			return cli.Exit("incremental backups must be restored from a file in the backup directory", 64)
+		}
+	}
+
+	// Incremental backups need the backups that came before them
+	backups, err := database.BackupChain(filepath.Dir(info.Path), info.Name)
+	if err != nil {
+		return fatalError(err, "could not find the backups needed for this incremental backup")
+	}
+
+	err = database.RestoreBackups(driver, backups, ctx.String("backup-passphrase"))
 	if err != nil {
+		if errors.Is(err, database.ErrCorruptedBackup) {
+			return fatalError(err, "backup failed verification, refusing to restore it")
+		}
 		return fatalError(err, "restore failed")
 	}
 
-	logger.Info("restored database from backup")
+	logger.Info("restored database from backup",
+		zap.Time("backup-time", info.Header.Time),
+		zap.String("backup-version", info.Header.Version),
+		zap.Int("backups-applied", len(backups)))
 	return nil
 }
 
+// keyFilter builds a key filter out of the --prefix and --exclude-prefix flags
+func keyFilter(ctx *cli.Context) database.KeyFilter {
+	return database.KeyFilter{
+		Include: ctx.StringSlice("prefix"),
+		Exclude: ctx.StringSlice("exclude-prefix"),
+	}
+}
+
+// parseBackupTime parses times given on the command line, either as a date/time (in local time unless
+// specified) or as a duration meaning "this long ago" (eg. 2h30m)
+func parseBackupTime(value string) (time.Time, error) {
+	if duration, err := time.ParseDuration(value); err == nil {
+		return time.Now().Add(-duration), nil
+	}
+	if at, err := time.Parse(time.RFC3339, value); err == nil {
+		return at, nil
+	}
+	for _, layout := range []string{"2006-01-02 15:04:05", "2006-01-02 15:04", "2006-01-02T15:04:05", "2006-01-02T15:04", "2006-01-02"} {
+		if at, err := time.ParseInLocation(layout, value, time.Local); err == nil {
+			return at, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("unrecognized time %q, use a date like \"2006-01-02 15:04\" or a duration like \"2h\"", value)
+}
+
+func cliBackupList(ctx *cli.Context) error {
+	backups, err := database.ListBackups(ctx.String("backup-dir"))
+	if err != nil {
+		return fatalError(err, "could not read backup directory")
+	}
+
+	writer := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	_, _ = fmt.Fprintln(writer, "NAME\tTIME\tKIND\tSIZE\tKEYS\tVERSION")
+	for _, backup := range backups {
+		kind := backup.Header.Kind
+		if kind == "" {
+			kind = database.BackupFull
+		}
+		if backup.Header.Encrypted {
+			kind += " (encrypted)"
+		}
+		_, _ = fmt.Fprintf(writer, "%s\t%s\t%s\t%s\t%d\t%s\n",
+			backup.Name,
+			backup.Header.Time.Local().Format("2006-01-02 15:04:05"),
+			kind,
+			formatSize(backup.Size),
+			backup.Header.Keys,
+			backup.Header.Version)
+	}
+	return writer.Flush()
+}
+
+func cliBackupDiff(ctx *cli.Context) error {
+	if ctx.NArg() != 2 {
+		return cli.Exit("usage: backup diff <old backup> <new backup>", 64)
+	}
+
+	prefix := ctx.String("prefix")
+	var states [2]map[string]string
+	for index := range states {
+		path := resolveBackupPath(ctx.String("backup-dir"), ctx.Args().Get(index))
+		state, err := database.ReadBackupState(filepath.Dir(path), filepath.Base(path), prefix, ctx.String("backup-passphrase"))
+		if err != nil {
+			return fatalError(err, "could not read backup")
+		}
+		states[index] = state
+	}
+
+	before, after := states[0], states[1]
+	diff := database.DiffStates(before, after)
+	showValues := ctx.Bool("values")
+	for _, key := range diff.Added {
+		if showValues {
+			fmt.Printf("+ %s = %s\n", key, after[key])
+		} else {
+			fmt.Printf("+ %s\n", key)
+		}
+	}
+	for _, key := range diff.Removed {
+		if showValues {
+			fmt.Printf("- %s = %s\n", key, before[key])
+		} else {
+			fmt.Printf("- %s\n", key)
+		}
+	}
+	for _, key := range diff.Changed {
+		if showValues {
+			fmt.Printf("~ %s = %s -> %s\n", key, before[key], after[key])
+		} else {
+			fmt.Printf("~ %s\n", key)
+		}
+	}
+
+	logger.Info("compared backups",
+		zap.Int("added", len(diff.Added)),
+		zap.Int("removed", len(diff.Removed)),
+		zap.Int("changed", len(diff.Changed)))
+	return nil
+}
+
+// resolveBackupPath accepts either a path to a backup or the name of a file in the backup directory
+func resolveBackupPath(backupDir string, name string) string {
+	if _, err := os.Stat(name); err == nil {
+		return name
+	}
+	return filepath.Join(backupDir, name)
+}
+
+func formatSize(size int64) string {
+	const unit = 1024
+	if size < unit {
+		return fmt.Sprintf("%d B", size)
+	}
+	div, exp := int64(unit), 0
+	for n := size / unit; n >= unit; n /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(size)/float64(div), "KMGTPE"[exp])
+}
+
+// openBackupFile opens a backup for reading, backups coming from STDIN are copied to a temporary file
+// since archives need to be read twice
+func openBackupFile(fileArg string) (*os.File, func(), error) {
+	if fileArg != "" {
+		file, err := os.Open(fileArg)
+		if err != nil {
+			return nil, nil, err
+		}
+		return file, func() { _ = file.Close() }, nil
+	}
+
+	file, err := os.CreateTemp("", "stul-restore-*")
+	if err != nil {
+		return nil, nil, err
+	}
+	closeFile := func() {
+		_ = file.Close()
+		_ = os.Remove(file.Name())
+	}
+	if _, err = io.Copy(file, os.Stdin); err != nil {
+		closeFile()
+		return nil, nil, err
+	}
+	if _, err = file.Seek(0, io.SeekStart); err != nil {
+		closeFile()
+		return nil, nil, err
+	}
+	return file, closeFile, nil
+}
+
 func cliExport(ctx *cli.Context) error {
 	outStream := os.Stdout
 	fileArg := ctx.String("file")
@@ -81,8 +300,14 @@ func cliExport(ctx *cli.Context) error {
 	if err != nil {
 		return fatalError(err, "could not open database")
 	}
+	defer func() {
+		warnOnError(driver.Close(), "could not close database")
+	}()
 
-	err = driver.Export(outStream)
+	err = database.ExportWithOptions(driver, outStream, database.ExportOptions{
+		Filter:         keyFilter(ctx),
+		IncludeSecrets: ctx.Bool("include-secrets"),
+	})
 	if err != nil {
 		return fatalError(err, "export failed")
 	}
@@ -90,3 +315,243 @@ func cliExport(ctx *cli.Context) error {
 	logger.Info("exported database")
 	return nil
 }
+
+func cliMigrate(ctx *cli.Context) error {
+	sourceName := database.GetDatabaseDriverName(ctx)
+	sourceDir := ctx.String("database-dir")
+	targetName := ctx.String("to-driver")
+	targetDir := ctx.String("to-dir")
+	if targetDir == "" {
+		targetDir = sourceDir
+	}
+
+	if !database.IsPersistent(targetName) {
+		return cli.Exit(fmt.Sprintf("%s is not a valid destination, data would not be saved", targetName), 64)
+	}
+	if sourceName == targetName && sourceDir == targetDir {
+		return cli.Exit("source and destination are the same database", 64)
+	}
+
+	source, err := database.OpenDriver(sourceName, sourceDir, logger)
+	if err != nil {
+		return fatalError(err, "could not open source database")
+	}
+	defer func() {
+		warnOnError(source.Close(), "could not close source database")
+	}()
+
+	// Encrypted secrets can only be read with the key they were encrypted with
+	if err = database.CopySecretKey(sourceDir, targetDir); err != nil {
+		return fatalError(err, "could not copy secret key")
+	}
+
+	target, err := database.OpenDriver(targetName, targetDir, logger)
+	if err != nil {
+		return fatalError(err, "could not open destination database")
+	}
+
+	summary, err := database.CopyDatabase(source, target)
+	if err != nil {
+		_ = target.Close()
+		return fatalError(err, "migration failed")
+	}
+	if err = target.Close(); err != nil {
+		return fatalError(err, "could not close destination database")
+	}
+
+	// Only switch driver once we know the data made it across
+	if err = database.WriteDriverFile(targetDir, targetName); err != nil {
+		return fatalError(err, "could not update driver file")
+	}
+
+	logger.Info("migrated database",
+		zap.String("from", sourceName),
+		zap.String("to", targetName),
+		zap.String("directory", targetDir),
+		zap.Int("keys", summary.Keys),
+		zap.String("sha256", hex.EncodeToString(summary.Checksum)))
+	return nil
+}
+
+func cliCompact(ctx *cli.Context) error {
+	driver, err := database.GetDatabaseDriver(ctx)
+	if err != nil {
+		return fatalError(err, "could not open database")
+	}
+	defer func() {
+		warnOnError(driver.Close(), "could not close database")
+	}()
+
+	db, err := startLocalClient(driver)
+	if err != nil {
+		return fatalError(err, "could not initialize database client")
+	}
+	defer func() {
+		warnOnError(db.Close(), "could not close database client")
+	}()
+
+	// Older versions "deleted" keys by emptying them, get rid of them for good
+	removed, err := database.PurgeEmptyKeys(driver, db)
+	if err != nil {
+		return fatalError(err, "could not delete empty keys")
+	}
+
+	if compacter, ok := driver.(database.Compacter); ok {
+		if err = compacter.Compact(); err != nil {
+			return fatalError(err, "could not compact database")
+		}
+	}
+
+	logger.Info("compacted database", zap.Int("keys-removed", removed))
+	return nil
+}
+
+func cliCheck(ctx *cli.Context) error {
+	driver, err := database.GetDatabaseDriver(ctx)
+	if err != nil {
+		return fatalError(err, "could not open database")
+	}
+	defer func() {
+		warnOnError(driver.Close(), "could not close database")
+	}()
+
+	report, err := database.CheckDatabase(driver)
+	if err != nil {
+		return fatalError(err, "could not check database")
+	}
+
+	database.SortIssues(report.Issues)
+	counts := make(map[string]int)
+	if len(report.Issues) > 0 {
+		writer := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		_, _ = fmt.Fprintln(writer, "PROBLEM\tKEY\tDETAILS")
+		for _, issue := range report.Issues {
+			counts[issue.Problem]++
+			_, _ = fmt.Fprintf(writer, "%s\t%s\t%s\n", issue.Problem, issue.Key, issue.Details)
+		}
+		if err = writer.Flush(); err != nil {
+			return err
+		}
+	}
+	logger.Info("checked database",
+		zap.Int("keys-checked", report.Checked),
+		zap.Int("keys-skipped", report.Skipped),
+		zap.Int("corrupt", counts[database.IssueCorrupt]),
+		zap.Int("empty", counts[database.IssueEmpty]),
+		zap.Int("unknown", counts[database.IssueUnknown]))
+
+	broken := counts[database.IssueCorrupt] + counts[database.IssueEmpty]
+	if broken == 0 {
+		return nil
+	}
+	if !ctx.Bool("repair") {
+		if counts[database.IssueCorrupt] > 0 {
+			return cli.Exit("found corrupt keys, run again with --repair to reset them", 1)
+		}
+		return nil
+	}
+
+	backupFile, err := database.BackupBeforeRepair(driver, getBackupOptions(ctx))
+	if err != nil {
+		return fatalError(err, "could not back up database, refusing to repair it")
+	}
+	logger.Info("backed up database before repairing it", zap.String("backup-file", backupFile))
+
+	db, err := startLocalClient(driver)
+	if err != nil {
+		return fatalError(err, "could not initialize database client")
+	}
+	defer func() {
+		warnOnError(db.Close(), "could not close database client")
+	}()
+
+	repaired, err := database.RepairDatabase(db, report.Issues)
+	if err != nil {
+		return fatalError(err, "repair failed")
+	}
+	logger.Info("repaired database", zap.Int("keys-reset", repaired))
+	return nil
+}
+
+// startLocalClient runs the hub of a driver and connects a client to it, for commands that need to go through kilovolt
+func startLocalClient(driver database.DatabaseDriver) (*database.LocalDBClient, error) {
+	hub := driver.Hub()
+	go hub.Run()
+	return database.NewLocalClient(hub, driver.Secrets(), logger)
+}
+
+// getBackupOptions reads the backup settings from the global flags
+func getBackupOptions(ctx *cli.Context) database.BackupOptions {
+	return database.BackupOptions{
+		DriverName:      database.GetDatabaseDriverName(ctx),
+		BackupDir:       ctx.String("backup-dir"),
+		BackupInterval:  ctx.Int("backup-interval"),
+		MaxBackups:      ctx.Int("max-backups"),
+		FullBackupEvery: ctx.Int("full-backup-every"),
+		Passphrase:      ctx.String("backup-passphrase"),
+	}
+}
+
+func cliStats(ctx *cli.Context) error {
+	driver, err := database.GetDatabaseDriver(ctx)
+	if err != nil {
+		return fatalError(err, "could not open database")
+	}
+	defer func() {
+		warnOnError(driver.Close(), "could not close database")
+	}()
+
+	stats, err := database.CollectStats(driver, database.StatsOptions{
+		Depth:       ctx.Int("depth"),
+		LargestKeys: ctx.Int("top"),
+		BackupDir:   ctx.String("backup-dir"),
+	})
+	if err != nil {
+		return fatalError(err, "could not collect database statistics")
+	}
+
+	if ctx.Bool("json") {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(stats)
+	}
+
+	writer := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	_, _ = fmt.Fprintf(writer, "Keys:\t%d\n", stats.Keys)
+	_, _ = fmt.Fprintf(writer, "Data size:\t%s\n", formatSize(stats.Size))
+	if stats.LastBackup != nil {
+		_, _ = fmt.Fprintf(writer, "Last backup:\t%s (%s ago)\n", stats.LastBackup.Local().Format("2006-01-02 15:04:05"), time.Since(*stats.LastBackup).Round(time.Second))
+	} else {
+		_, _ = fmt.Fprintln(writer, "Last backup:\tnever")
+	}
+	if stats.Storage != nil {
+		_, _ = fmt.Fprintf(writer, "Disk size:\t%s\n", formatSize(stats.Storage.DiskSize))
+		if stats.Storage.Reclaimable > 0 {
+			_, _ = fmt.Fprintf(writer, "Reclaimable:\t%s (run compact to free it)\n", formatSize(stats.Storage.Reclaimable))
+		}
+		if len(stats.Storage.Levels) > 0 {
+			_, _ = fmt.Fprintf(writer, "Compaction debt:\t%s\n", formatSize(int64(stats.Storage.CompactionDebt)))
+			_, _ = fmt.Fprintf(writer, "WAL:\t%s in %d files\n", formatSize(int64(stats.Storage.WALSize)), stats.Storage.WALFiles)
+		}
+	}
+
+	_, _ = fmt.Fprintln(writer, "\nPREFIX\tKEYS\tSIZE")
+	for _, prefix := range stats.Prefixes {
+		_, _ = fmt.Fprintf(writer, "%s\t%d\t%s\n", prefix.Prefix, prefix.Keys, formatSize(prefix.Size))
+	}
+
+	if len(stats.LargestKeys) > 0 {
+		_, _ = fmt.Fprintln(writer, "\nLARGEST KEYS\t\tSIZE")
+		for _, key := range stats.LargestKeys {
+			_, _ = fmt.Fprintf(writer, "%s\t\t%s\n", key.Key, formatSize(key.Size))
+		}
+	}
+
+	if stats.Storage != nil && len(stats.Storage.Levels) > 0 {
+		_, _ = fmt.Fprintln(writer, "\nLEVEL\tFILES\tSIZE")
+		for _, level := range stats.Storage.Levels {
+			_, _ = fmt.Fprintf(writer, "L%d\t%d\t%s\n", level.Level, level.Files, formatSize(level.Size))
+		}
+	}
+	return writer.Flush()
+}
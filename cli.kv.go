@@ -0,0 +1,413 @@
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"os/signal"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/gorilla/websocket"
+	jsoniter "github.com/json-iterator/go"
+	kv "github.com/strimertul/kilovolt/v9"
+	"github.com/urfave/cli/v2"
+	"go.uber.org/zap"
+
+	"github.com/strimertul/strimertul/database"
+)
+
+// kvStore is what the kv commands work with, either a database directory or a running instance
+type kvStore interface {
+	Get(key string) (string, error)
+	Put(key string, value string) error
+	List(prefix string) ([]string, error)
+	Delete(key string) error
+	Watch(prefix string, fn func(key, value string)) error
+	Close() error
+}
+
+// openKVStore connects to the instance given with --remote, or opens the database directory if there isn't one
+func openKVStore(ctx *cli.Context) (kvStore, error) {
+	if remote := ctx.String("remote"); remote != "" {
+		return dialKilovolt(remote, ctx.String("password"), ctx.String("ca-cert"))
+	}
+
+	driver, err := database.GetDatabaseDriver(ctx)
+	if err != nil {
+		return nil, err
+	}
+	db, err := startLocalClient(driver)
+	if err != nil {
+		_ = driver.Close()
+		return nil, err
+	}
+	return &localKVStore{db: db, driver: driver}, nil
+}
+
+func cliKVGet(ctx *cli.Context) error {
+	if ctx.NArg() < 1 {
+		return cli.Exit("usage: kv get <key> [key...]", 64)
+	}
+	store, err := openKVStore(ctx)
+	if err != nil {
+		return fatalError(err, "could not open database")
+	}
+	defer closeKVStore(store)
+
+	for _, key := range ctx.Args().Slice() {
+		value, err := store.Get(key)
+		if err != nil {
+			return fatalError(err, "could not read key")
+		}
+		if ctx.NArg() > 1 {
+			fmt.Printf("%s = %s\n", key, value)
+		} else {
+			fmt.Println(value)
+		}
+	}
+	return nil
+}
+
+func cliKVPut(ctx *cli.Context) error {
+	if ctx.NArg() != 2 {
+		return cli.Exit("usage: kv put <key> <value> (use - as value to read it from STDIN)", 64)
+	}
+	key, value := ctx.Args().Get(0), ctx.Args().Get(1)
+	if value == "-" {
+		data, err := io.ReadAll(os.Stdin)
+		if err != nil {
+			return fatalError(err, "could not read value")
+		}
+		value = string(data)
+	}
+
+	store, err := openKVStore(ctx)
+	if err != nil {
+		return fatalError(err, "could not open database")
+	}
+	defer closeKVStore(store)
+
+	if err = store.Put(key, value); err != nil {
+		return fatalError(err, "could not write key")
+	}
+	logger.Info("wrote key", zap.String("key", key))
+	return nil
+}
+
+func cliKVList(ctx *cli.Context) error {
+	store, err := openKVStore(ctx)
+	if err != nil {
+		return fatalError(err, "could not open database")
+	}
+	defer closeKVStore(store)
+
+	keys, err := store.List(ctx.Args().First())
+	if err != nil {
+		return fatalError(err, "could not list keys")
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		fmt.Println(key)
+	}
+	return nil
+}
+
+func cliKVDelete(ctx *cli.Context) error {
+	if ctx.NArg() < 1 {
+		return cli.Exit("usage: kv del <key> [key...]", 64)
+	}
+	store, err := openKVStore(ctx)
+	if err != nil {
+		return fatalError(err, "could not open database")
+	}
+	defer closeKVStore(store)
+
+	for _, key := range ctx.Args().Slice() {
+		if err = store.Delete(key); err != nil {
+			return fatalError(err, "could not delete key")
+		}
+		logger.Info("deleted key", zap.String("key", key))
+	}
+	return nil
+}
+
+func cliKVWatch(ctx *cli.Context) error {
+	// Nothing else can write to a database directory while we have it open
+	if ctx.String("remote") == "" {
+		return cli.Exit("watch needs a running instance to watch, connect to it with --remote", 64)
+	}
+	store, err := openKVStore(ctx)
+	if err != nil {
+		return fatalError(err, "could not connect")
+	}
+	defer closeKVStore(store)
+
+	err = store.Watch(ctx.Args().First(), func(key, value string) {
+		fmt.Printf("%s = %s\n", key, value)
+	})
+	if err != nil {
+		return fatalError(err, "could not subscribe")
+	}
+
+	interrupt := make(chan os.Signal, 1)
+	signal.Notify(interrupt, os.Interrupt)
+	<-interrupt
+	return nil
+}
+
+func closeKVStore(store kvStore) {
+	warnOnError(store.Close(), "could not close database")
+}
+
+// localKVStore works on a database directory
+type localKVStore struct {
+	db     *database.LocalDBClient
+	driver database.DatabaseDriver
+}
+
+func (s *localKVStore) Get(key string) (string, error) {
+	return s.db.GetKey(key)
+}
+
+func (s *localKVStore) Put(key string, value string) error {
+	return s.db.PutKey(key, value)
+}
+
+func (s *localKVStore) List(prefix string) ([]string, error) {
+	return s.db.ListKeys(prefix)
+}
+
+func (s *localKVStore) Delete(key string) error {
+	return s.db.RemoveKey(key)
+}
+
+func (s *localKVStore) Watch(prefix string, fn func(key, value string)) error {
+	err, _ := s.db.SubscribePrefix(fn, prefix)
+	return err
+}
+
+func (s *localKVStore) Close() error {
+	_ = s.db.Close()
+	return s.driver.Close()
+}
+
+// kilovoltMessage is any message coming from a kilovolt server
+type kilovoltMessage struct {
+	Type      string              `json:"type"`
+	OK        bool                `json:"ok"`
+	RequestID string              `json:"request_id"`
+	Data      jsoniter.RawMessage `json:"data"`
+	Error     string              `json:"error"`
+	Details   string              `json:"details"`
+	Key       string              `json:"key"`
+	NewValue  string              `json:"new_value"`
+}
+
+// remoteKVStore talks to a running instance over the kilovolt websocket
+type remoteKVStore struct {
+	conn *websocket.Conn
+
+	mu       sync.Mutex
+	nextID   int
+	pending  map[string]chan kilovoltMessage
+	watchers []func(key, value string)
+	err      error
+}
+
+func dialKilovolt(address string, password string, caCert string) (*remoteKVStore, error) {
+	endpoint := url.URL{Scheme: "ws", Host: address, Path: "/ws"}
+	// Also accept full URLs, eg. wss://host:port for instances with TLS enabled
+	if strings.Contains(address, "://") {
+		parsed, err := url.Parse(address)
+		if err != nil {
+			return nil, fmt.Errorf("invalid address %s: %w", address, err)
+		}
+		endpoint = url.URL{Scheme: parsed.Scheme, Host: parsed.Host, Path: "/ws"}
+	}
+
+	dialer := *websocket.DefaultDialer
+	if caCert != "" {
+		pem, err := os.ReadFile(caCert)
+		if err != nil {
+			return nil, fmt.Errorf("could not read certificate: %w", err)
+		}
+		roots := x509.NewCertPool()
+		if !roots.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in %s", caCert)
+		}
+		dialer.TLSClientConfig = &tls.Config{RootCAs: roots}
+	}
+
+	conn, _, err := dialer.Dial(endpoint.String(), nil)
+	if err != nil {
+		return nil, fmt.Errorf("could not connect to %s: %w", endpoint.String(), err)
+	}
+
+	store := &remoteKVStore{
+		conn:    conn,
+		pending: make(map[string]chan kilovoltMessage),
+	}
+	go store.read()
+
+	if password != "" {
+		if err = store.authenticate(password); err != nil {
+			_ = conn.Close()
+			return nil, err
+		}
+	}
+	return store, nil
+}
+
+func (s *remoteKVStore) authenticate(password string) error {
+	var challenge struct {
+		Challenge string `json:"challenge"`
+		Salt      string `json:"salt"`
+	}
+	if err := s.request(kv.CmdAuthRequest, nil, &challenge); err != nil {
+		return fmt.Errorf("could not start authentication: %w", err)
+	}
+	challengeBytes, err := base64.StdEncoding.DecodeString(challenge.Challenge)
+	if err != nil {
+		return err
+	}
+	salt, err := base64.StdEncoding.DecodeString(challenge.Salt)
+	if err != nil {
+		return err
+	}
+
+	hash := hmac.New(sha256.New, append([]byte(password), salt...))
+	hash.Write(challengeBytes)
+	err = s.request(kv.CmdAuthChallenge, map[string]interface{}{
+		"hash": base64.StdEncoding.EncodeToString(hash.Sum(nil)),
+	}, nil)
+	if err != nil {
+		return fmt.Errorf("authentication failed, check the kilovolt password: %w", err)
+	}
+	return nil
+}
+
+func (s *remoteKVStore) read() {
+	for {
+		_, data, err := s.conn.ReadMessage()
+		if err != nil {
+			s.fail(err)
+			return
+		}
+
+		// Messages queued up on the server are sent together, one per line
+		for _, line := range bytes.Split(data, []byte("\n")) {
+			var message kilovoltMessage
+			if err = json.Unmarshal(line, &message); err != nil {
+				s.fail(err)
+				return
+			}
+			s.dispatch(message)
+		}
+	}
+}
+
+func (s *remoteKVStore) dispatch(message kilovoltMessage) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if message.Type == "push" {
+		for _, fn := range s.watchers {
+			fn(message.Key, message.NewValue)
+		}
+	} else if channel, ok := s.pending[message.RequestID]; ok {
+		channel <- message
+		delete(s.pending, message.RequestID)
+	}
+}
+
+// fail stops every pending request, once the connection can't be read anymore
+func (s *remoteKVStore) fail(err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.err = err
+	for id, channel := range s.pending {
+		close(channel)
+		delete(s.pending, id)
+	}
+}
+
+func (s *remoteKVStore) request(command string, data map[string]interface{}, result interface{}) error {
+	s.mu.Lock()
+	if s.err != nil {
+		s.mu.Unlock()
+		return s.err
+	}
+	s.nextID++
+	id := strconv.Itoa(s.nextID)
+	channel := make(chan kilovoltMessage, 1)
+	s.pending[id] = channel
+	s.mu.Unlock()
+
+	message, err := json.Marshal(kv.Request{CmdName: command, RequestID: id, Data: data})
+	if err != nil {
+		return err
+	}
+	if err = s.conn.WriteMessage(websocket.TextMessage, message); err != nil {
+		return err
+	}
+
+	response, ok := <-channel
+	if !ok {
+		return fmt.Errorf("connection closed: %w", s.err)
+	}
+	if !response.OK {
+		return fmt.Errorf("%s: %s", response.Error, response.Details)
+	}
+	if result == nil || len(response.Data) == 0 {
+		return nil
+	}
+	return json.Unmarshal(response.Data, result)
+}
+
+func (s *remoteKVStore) Get(key string) (string, error) {
+	var value string
+	err := s.request(kv.CmdReadKey, map[string]interface{}{"key": key}, &value)
+	return value, err
+}
+
+func (s *remoteKVStore) Put(key string, value string) error {
+	return s.request(kv.CmdWriteKey, map[string]interface{}{"key": key, "data": value}, nil)
+}
+
+func (s *remoteKVStore) List(prefix string) ([]string, error) {
+	var keys []string
+	err := s.request(kv.CmdListKeys, map[string]interface{}{"prefix": prefix}, &keys)
+	return keys, err
+}
+
+func (s *remoteKVStore) Delete(key string) error {
+	return s.request(kv.CmdRemoveKey, map[string]interface{}{"key": key}, nil)
+}
+
+func (s *remoteKVStore) Watch(prefix string, fn func(key, value string)) error {
+	s.mu.Lock()
+	s.watchers = append(s.watchers, fn)
+	s.mu.Unlock()
+	return s.request(kv.CmdSubscribePrefix, map[string]interface{}{"prefix": prefix}, nil)
+}
+
+func (s *remoteKVStore) Close() error {
+	err := s.conn.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""))
+	if closeErr := s.conn.Close(); err == nil {
+		err = closeErr
+	}
+	if errors.Is(err, websocket.ErrCloseSent) {
+		return nil
+	}
+	return err
+}
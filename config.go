@@ -0,0 +1,473 @@
+package main
+
+import (
+	"bytes"
+	stdjson "encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+	"gopkg.in/yaml.v3"
+
+	"github.com/strimertul/strimertul/database"
+	"github.com/strimertul/strimertul/http"
+	"github.com/strimertul/strimertul/loyalty"
+	"github.com/strimertul/strimertul/twitch"
+)
+
+// configSection is a part of the config file, stored in a single key
+type configSection struct {
+	Name     string
+	Key      string
+	Value    interface{}        // A value of the type stored in the key
+	Defaults func() interface{} // What the module stores when the key is missing, nil for the zero value
+}
+
+var configSections = []configSection{
+	{Name: "http", Key: http.ServerConfigKey, Value: http.ServerConfig{}, Defaults: func() interface{} { return http.DefaultServerConfig() }},
+	{Name: "twitch", Key: twitch.ConfigKey, Value: twitch.Config{}},
+	{Name: "bot", Key: twitch.BotConfigKey, Value: twitch.BotConfig{}},
+	{Name: "commands", Key: twitch.CustomCommandsKey, Value: map[string]twitch.BotCustomCommand{}},
+	{Name: "timers", Key: twitch.BotTimersKey, Value: twitch.BotTimersConfig{}},
+	{Name: "alerts", Key: twitch.BotAlertsKey, Value: twitch.BotAlertsConfig{}},
+	{Name: "loyalty", Key: loyalty.ConfigKey, Value: loyalty.Config{}},
+	{Name: "rewards", Key: loyalty.RewardsKey, Value: []loyalty.Reward{}},
+}
+
+const (
+	// configModeSeed only writes sections whose key isn't set yet
+	configModeSeed = "seed"
+	// configModeSync makes the file the source of truth, applied on startup and whenever it changes, with changes
+	// from the dashboard written back to it
+	configModeSync = "sync"
+)
+
+// configPollInterval is how often the config file is checked for changes in sync mode
+const configPollInterval = 2 * time.Second
+
+// isObject returns true if the section is a set of fields that are merged with what's stored, rather than a collection
+// (eg. custom commands) that replaces it as a whole
+func (s configSection) isObject() bool {
+	return reflect.TypeOf(s.Value).Kind() == reflect.Struct
+}
+
+// decode reads the content of the section from the file on top of base (nil to start from an empty value),
+// failing on fields the section doesn't have or values of the wrong type
+func (s configSection) decode(base interface{}, content interface{}) (interface{}, error) {
+	value := reflect.New(reflect.TypeOf(s.Value))
+	if base != nil && s.isObject() {
+		value.Elem().Set(reflect.ValueOf(base))
+	}
+
+	byt, err := stdjson.Marshal(content)
+	if err != nil {
+		return nil, fmt.Errorf("section %s: %w", s.Name, err)
+	}
+	decoder := stdjson.NewDecoder(bytes.NewReader(byt))
+	decoder.DisallowUnknownFields()
+	if err = decoder.Decode(value.Interface()); err != nil {
+		return nil, fmt.Errorf("section %s: %w", s.Name, err)
+	}
+	return value.Elem().Interface(), nil
+}
+
+func findConfigSection(name string) (configSection, bool) {
+	for _, section := range configSections {
+		if section.Name == name {
+			return section, true
+		}
+	}
+	return configSection{}, false
+}
+
+func configSectionNames() string {
+	names := make([]string, len(configSections))
+	for index, section := range configSections {
+		names[index] = section.Name
+	}
+	return strings.Join(names, ", ")
+}
+
+// ConfigFile is a YAML (or JSON) file holding some of the configuration modules store in the database,
+// one top-level section per key (see configSections)
+type ConfigFile struct {
+	path string
+
+	mu       sync.Mutex
+	document yaml.Node              // Kept around to write changes back without losing comments
+	sections map[string]interface{} // Content of each section, as read from the file
+	modTime  time.Time
+}
+
+// LoadConfigFile reads and validates a config file
+func LoadConfigFile(path string) (*ConfigFile, error) {
+	config := &ConfigFile{path: path}
+	if err := config.load(); err != nil {
+		return nil, err
+	}
+	return config, nil
+}
+
+func (c *ConfigFile) load() error {
+	info, err := os.Stat(c.path)
+	if err != nil {
+		return err
+	}
+	data, err := os.ReadFile(c.path)
+	if err != nil {
+		return err
+	}
+
+	var document yaml.Node
+	if err = yaml.Unmarshal(data, &document); err != nil {
+		return fmt.Errorf("could not parse %s: %w", c.path, err)
+	}
+	sections := make(map[string]interface{})
+	// An empty file has no document at all
+	if document.Kind != 0 {
+		if err = document.Decode(&sections); err != nil {
+			return fmt.Errorf("could not parse %s: %w", c.path, err)
+		}
+	}
+
+	for name, content := range sections {
+		section, ok := findConfigSection(name)
+		if !ok {
+			return fmt.Errorf("unknown section %q in %s (valid sections are %s)", name, c.path, configSectionNames())
+		}
+		if _, err = section.decode(nil, content); err != nil {
+			return fmt.Errorf("invalid config in %s: %w", c.path, err)
+		}
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.document = document
+	c.sections = sections
+	c.modTime = info.ModTime()
+	return nil
+}
+
+// Apply writes the sections in the file to the database. Fields left out of an object section (eg. http) keep
+// what's stored, or the module defaults if nothing is. In seed mode, sections that are already stored are skipped.
+func (c *ConfigFile) Apply(db *database.LocalDBClient, mode string) error {
+	if mode != configModeSeed && mode != configModeSync {
+		return fmt.Errorf("unknown config mode %q, must be %s or %s", mode, configModeSeed, configModeSync)
+	}
+
+	// Writing notifies the subscription in Sync, which needs the lock to write back
+	c.mu.Lock()
+	sections := make(map[string]interface{}, len(c.sections))
+	for name, content := range c.sections {
+		sections[name] = content
+	}
+	c.mu.Unlock()
+
+	for _, section := range configSections {
+		content, ok := sections[section.Name]
+		if !ok {
+			continue
+		}
+		current, err := db.GetSecret(section.Key)
+		if err != nil {
+			return err
+		}
+		if current != "" && mode == configModeSeed {
+			continue
+		}
+
+		var base interface{}
+		if current != "" {
+			stored := reflect.New(reflect.TypeOf(section.Value))
+			if err = json.UnmarshalFromString(current, stored.Interface()); err == nil {
+				base = stored.Elem().Interface()
+			}
+		}
+		if base == nil && section.Defaults != nil {
+			base = section.Defaults()
+		}
+
+		value, err := section.decode(base, content)
+		if err != nil {
+			return err
+		}
+		byt, err := json.Marshal(value)
+		if err != nil {
+			return err
+		}
+		if string(byt) == current {
+			continue
+		}
+		if err = db.PutJSON(section.Key, value); err != nil {
+			return fmt.Errorf("could not write %s: %w", section.Key, err)
+		}
+		logger.Info("applied config file section", zap.String("section", section.Name), zap.String("key", section.Key))
+	}
+	return nil
+}
+
+// Sync keeps the file and the database in sync until the returned function is called: changes to the file are
+// applied to the database, and changes made to its keys (eg. from the dashboard) are written back to the file.
+// Only the sections and fields already in the file are written back, so secrets left out of it stay out.
+func (c *ConfigFile) Sync(db *database.LocalDBClient) (func(), error) {
+	var cancels []database.CancelFunc
+	stop := func() {
+		for _, cancel := range cancels {
+			cancel()
+		}
+	}
+	for _, section := range configSections {
+		section := section
+		err, cancel := db.SubscribeKey(section.Key, func(string) {
+			warnOnError(c.writeBack(db, section), "could not write changes back to config file", zap.String("section", section.Name))
+		})
+		if err != nil {
+			stop()
+			return nil, err
+		}
+		cancels = append(cancels, cancel)
+	}
+
+	exit := make(chan struct{})
+	go func() {
+		ticker := time.NewTicker(configPollInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-exit:
+				return
+			case <-ticker.C:
+				c.reload(db)
+			}
+		}
+	}()
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			close(exit)
+			stop()
+		})
+	}, nil
+}
+
+// reload applies the file again if it changed since it was last read
+func (c *ConfigFile) reload(db *database.LocalDBClient) {
+	info, err := os.Stat(c.path)
+	if err != nil {
+		logger.Warn("could not check config file for changes", zap.String("path", c.path), zap.Error(err))
+		return
+	}
+	c.mu.Lock()
+	changed := !info.ModTime().Equal(c.modTime)
+	c.mu.Unlock()
+	if !changed {
+		return
+	}
+
+	// Keep going with what we have if the new file is broken, it's likely still being edited
+	if err = c.load(); err != nil {
+		logger.Error("could not reload config file", zap.String("path", c.path), zap.Error(err))
+		return
+	}
+	logger.Info("config file changed, applying it", zap.String("path", c.path))
+	warnOnError(c.Apply(db, configModeSync), "could not apply config file")
+}
+
+// writeBack updates the file with what's stored for a section, if it's different from what the file has
+func (c *ConfigFile) writeBack(db *database.LocalDBClient, section configSection) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	content, ok := c.sections[section.Name]
+	if !ok {
+		return nil
+	}
+	current, err := db.GetSecret(section.Key)
+	if err != nil || current == "" {
+		return err
+	}
+	var stored interface{}
+	if err = stdjson.Unmarshal([]byte(current), &stored); err != nil {
+		return err
+	}
+
+	updated := stored
+	fields, isMap := content.(map[string]interface{})
+	storedFields, storedIsMap := stored.(map[string]interface{})
+	if section.isObject() && isMap && storedIsMap {
+		// Only update the fields in the file
+		updatedFields := make(map[string]interface{}, len(fields))
+		for name, value := range fields {
+			updatedFields[name] = value
+			if storedValue, ok := storedFields[name]; ok {
+				updatedFields[name] = storedValue
+			}
+		}
+		updated = updatedFields
+	}
+
+	same, err := sameJSON(content, updated)
+	if err != nil || same {
+		return err
+	}
+	c.sections[section.Name] = updated
+
+	var data []byte
+	if strings.EqualFold(filepath.Ext(c.path), ".json") {
+		data, err = stdjson.MarshalIndent(c.sections, "", "  ")
+		data = append(data, '\n')
+	} else {
+		data, err = c.updateDocument(section, updated)
+	}
+	if err != nil {
+		return err
+	}
+	if err = os.WriteFile(c.path, data, 0o644); err != nil {
+		return err
+	}
+	// Don't apply our own write when polling for changes
+	if info, err := os.Stat(c.path); err == nil {
+		c.modTime = info.ModTime()
+	}
+	logger.Info("wrote changes back to config file", zap.String("section", section.Name), zap.String("path", c.path))
+	return nil
+}
+
+// updateDocument replaces the content of a section in the YAML document, keeping comments and ordering
+func (c *ConfigFile) updateDocument(section configSection, updated interface{}) ([]byte, error) {
+	if len(c.document.Content) == 0 {
+		return nil, errors.New("config file is empty")
+	}
+	root := c.document.Content[0]
+	node := mappingValue(root, section.Name)
+	if node == nil {
+		return nil, fmt.Errorf("section %s not found in config file", section.Name)
+	}
+
+	fields, isMap := updated.(map[string]interface{})
+	if section.isObject() && isMap && node.Kind == yaml.MappingNode {
+		for name, value := range fields {
+			if field := mappingValue(node, name); field != nil {
+				if err := replaceNode(field, value); err != nil {
+					return nil, err
+				}
+			}
+		}
+	} else if err := replaceNode(node, updated); err != nil {
+		return nil, err
+	}
+
+	var buffer bytes.Buffer
+	encoder := yaml.NewEncoder(&buffer)
+	encoder.SetIndent(2)
+	if err := encoder.Encode(&c.document); err != nil {
+		return nil, err
+	}
+	if err := encoder.Close(); err != nil {
+		return nil, err
+	}
+	return buffer.Bytes(), nil
+}
+
+// mappingValue returns the node holding the value of key in a YAML mapping, nil if there is none
+func mappingValue(node *yaml.Node, key string) *yaml.Node {
+	if node.Kind != yaml.MappingNode {
+		return nil
+	}
+	for index := 0; index+1 < len(node.Content); index += 2 {
+		if node.Content[index].Value == key {
+			return node.Content[index+1]
+		}
+	}
+	return nil
+}
+
+// replaceNode sets the value of a YAML node, keeping its comments
+func replaceNode(node *yaml.Node, value interface{}) error {
+	var replacement yaml.Node
+	if err := replacement.Encode(value); err != nil {
+		return err
+	}
+	replacement.HeadComment = node.HeadComment
+	replacement.LineComment = node.LineComment
+	replacement.FootComment = node.FootComment
+	*node = replacement
+	return nil
+}
+
+// sameJSON compares two values as they would be encoded in JSON, so that numbers read from YAML (int)
+// and from JSON (float64) compare equal
+func sameJSON(a interface{}, b interface{}) (bool, error) {
+	normalize := func(value interface{}) (interface{}, error) {
+		byt, err := stdjson.Marshal(value)
+		if err != nil {
+			return nil, err
+		}
+		var normalized interface{}
+		err = stdjson.Unmarshal(byt, &normalized)
+		return normalized, err
+	}
+	normalA, err := normalize(a)
+	if err != nil {
+		return false, err
+	}
+	normalB, err := normalize(b)
+	if err != nil {
+		return false, err
+	}
+	return reflect.DeepEqual(normalA, normalB), nil
+}
+
+// DumpConfig returns the config stored in the database as a config file, with secrets left out unless includeSecrets
+// is set. Leaving them out means they keep whatever is stored when the file is applied.
+func DumpConfig(db *database.LocalDBClient, includeSecrets bool) (map[string]interface{}, error) {
+	sections := make(map[string]interface{})
+	for _, section := range configSections {
+		value, err := db.GetSecret(section.Key)
+		if err != nil {
+			return nil, err
+		}
+		if value == "" {
+			continue
+		}
+		var content interface{}
+		if err = stdjson.Unmarshal([]byte(value), &content); err != nil {
+			return nil, fmt.Errorf("could not read %s: %w", section.Key, err)
+		}
+		if fields, ok := database.SecretFields(section.Key); ok && !includeSecrets {
+			object, isMap := content.(map[string]interface{})
+			if fields == nil || !isMap {
+				continue
+			}
+			for _, field := range fields {
+				delete(object, field)
+			}
+		}
+		sections[section.Name] = content
+	}
+	return sections, nil
+}
+
+// sortedSectionNames lists the sections of a dump in the order of configSections
+func sortedSectionNames(sections map[string]interface{}) []string {
+	order := make(map[string]int, len(configSections))
+	for index, section := range configSections {
+		order[section.Name] = index
+	}
+	names := make([]string, 0, len(sections))
+	for name := range sections {
+		names = append(names, name)
+	}
+	sort.Slice(names, func(i, j int) bool {
+		return order[names[i]] < order[names[j]]
+	})
+	return names
+}
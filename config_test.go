@@ -0,0 +1,186 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/strimertul/strimertul/database"
+	"github.com/strimertul/strimertul/twitch"
+)
+
+func newTestConfigDatabase(t *testing.T) *database.LocalDBClient {
+	t.Helper()
+	logger = zap.NewNop()
+	driver, err := database.NewInMemory(logger)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		_ = driver.Close()
+	})
+	go driver.Hub().Run()
+	db, err := database.NewLocalClient(driver.Hub(), driver.Secrets(), logger)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		_ = db.Close()
+	})
+	return db
+}
+
+func writeTestConfig(t *testing.T, name string, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLoadConfigFile(t *testing.T) {
+	tests := []struct {
+		name    string
+		file    string
+		content string
+		valid   bool
+	}{
+		{"yaml", "config.yaml", "bot:\n  channel: somechannel\n  chat_history: 10\n", true},
+		{"json", "config.json", `{"bot": {"channel": "somechannel"}}`, true},
+		{"empty file", "config.yaml", "", true},
+		{"unknown section", "config.yaml", "bots:\n  channel: somechannel\n", false},
+		{"unknown field", "config.yaml", "bot:\n  chanel: somechannel\n", false},
+		{"wrong type", "config.yaml", "bot:\n  chat_history: lots\n", false},
+		{"not a mapping", "config.yaml", "- bot\n", false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := LoadConfigFile(writeTestConfig(t, test.file, test.content))
+			if test.valid && err != nil {
+				t.Fatalf("valid config refused: %s", err)
+			}
+			if !test.valid && err == nil {
+				t.Fatal("invalid config accepted")
+			}
+		})
+	}
+}
+
+func TestApplyConfig(t *testing.T) {
+	tests := []struct {
+		mode     string
+		expected twitch.BotConfig
+	}{
+		// Seed mode leaves sections that are already stored alone
+		{configModeSeed, twitch.BotConfig{Username: "stored", Channel: "stored", ChatHistory: 5}},
+		// Sync mode overwrites the fields in the file, keeping the others
+		{configModeSync, twitch.BotConfig{Username: "stored", Channel: "fromfile", ChatHistory: 5}},
+	}
+	for _, test := range tests {
+		t.Run(test.mode, func(t *testing.T) {
+			db := newTestConfigDatabase(t)
+			err := db.PutJSON(twitch.BotConfigKey, twitch.BotConfig{Username: "stored", Channel: "stored", ChatHistory: 5})
+			if err != nil {
+				t.Fatal(err)
+			}
+			config, err := LoadConfigFile(writeTestConfig(t, "config.yaml", "bot:\n  channel: fromfile\ncommands:\n  \"!hello\":\n    response: hi\n    enabled: true\n"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err = config.Apply(db, test.mode); err != nil {
+				t.Fatalf("could not apply config: %s", err)
+			}
+
+			var bot twitch.BotConfig
+			if err = db.GetJSON(twitch.BotConfigKey, &bot); err != nil {
+				t.Fatal(err)
+			}
+			if bot != test.expected {
+				t.Fatalf("bot config is %+v, expected %+v", bot, test.expected)
+			}
+			// Sections that aren't stored are written in both modes
+			var commands map[string]twitch.BotCustomCommand
+			if err = db.GetJSON(twitch.CustomCommandsKey, &commands); err != nil {
+				t.Fatal(err)
+			}
+			if commands["!hello"].Response != "hi" {
+				t.Fatalf("commands were not written: %+v", commands)
+			}
+		})
+	}
+
+	db := newTestConfigDatabase(t)
+	config, err := LoadConfigFile(writeTestConfig(t, "config.yaml", "bot:\n  channel: fromfile\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = config.Apply(db, "mirror"); err == nil {
+		t.Fatal("unknown mode accepted")
+	}
+}
+
+func TestConfigSync(t *testing.T) {
+	db := newTestConfigDatabase(t)
+	path := writeTestConfig(t, "config.yaml", "# Bot setup\nbot:\n  channel: fromfile # the channel\n")
+	config, err := LoadConfigFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = config.Apply(db, configModeSync); err != nil {
+		t.Fatal(err)
+	}
+	stop, err := config.Sync(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stop()
+
+	// Dashboard changes go back to the file, without adding the fields it didn't have (like the token)
+	err = db.PutJSON(twitch.BotConfigKey, twitch.BotConfig{Channel: "fromdashboard", Token: "oauth:secret"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var content string
+	deadline := time.Now().Add(time.Second)
+	for time.Now().Before(deadline) {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if content = string(data); strings.Contains(content, "fromdashboard") {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if !strings.Contains(content, "fromdashboard") {
+		t.Fatalf("change was not written back to the file:\n%s", content)
+	}
+	if !strings.Contains(content, "# Bot setup") || !strings.Contains(content, "# the channel") {
+		t.Fatalf("comments were lost:\n%s", content)
+	}
+	if strings.Contains(content, "secret") || strings.Contains(content, "oauth") {
+		t.Fatalf("fields missing from the file were added:\n%s", content)
+	}
+
+	// File changes are applied to the database
+	if err = os.WriteFile(path, []byte("bot:\n  channel: edited\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	later := time.Now().Add(time.Minute)
+	if err = os.Chtimes(path, later, later); err != nil {
+		t.Fatal(err)
+	}
+	config.reload(db)
+	var bot twitch.BotConfig
+	if err = db.GetJSON(twitch.BotConfigKey, &bot); err != nil {
+		t.Fatal(err)
+	}
+	if bot.Channel != "edited" {
+		t.Fatalf("file change was not applied, channel is %q", bot.Channel)
+	}
+}
@@ -0,0 +1,305 @@
+package database
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"filippo.io/age"
+	"github.com/klauspost/compress/zstd"
+)
+
+// archiveMagic marks the beginning of a backup archive
+var archiveMagic = []byte("STULBAK1")
+
+// maxHeaderSize is the biggest header we are willing to read, anything larger means the file is damaged
+const maxHeaderSize = 64 * 1024
+
+var (
+	// ErrNotAnArchive is returned when a file does not start with the backup archive signature
+	ErrNotAnArchive = errors.New("not a backup archive")
+
+	// ErrCorruptedBackup is returned when a backup archive fails its integrity check
+	ErrCorruptedBackup = errors.New("backup is corrupted or truncated")
+
+	// ErrPassphraseRequired is returned when opening an encrypted backup without a passphrase
+	ErrPassphraseRequired = errors.New("backup is encrypted, a passphrase is required")
+
+	// ErrWrongPassphrase is returned when an encrypted backup can't be decrypted with the given passphrase
+	ErrWrongPassphrase = errors.New("wrong backup passphrase")
+)
+
+// Backup kinds
+const (
+	BackupFull        = "full"
+	BackupIncremental = "incremental"
+)
+
+// BackupHeader contains information about a backup archive, it's stored uncompressed at the beginning of the file
+type BackupHeader struct {
+	Version   string    `json:"version"`             // strimertul version at the time of the backup (stul-meta/version)
+	Driver    string    `json:"driver"`              // Database driver the backup was taken from
+	Time      time.Time `json:"time"`                // When the backup was taken
+	Keys      int       `json:"keys"`                // Number of keys in the backup
+	Checksum  string    `json:"checksum"`            // SHA-256 of the uncompressed payload, hex encoded
+	Kind      string    `json:"kind,omitempty"`      // Full snapshot or incremental (only changed keys), empty means full
+	Base      string    `json:"base,omitempty"`      // For incremental backups, file name of the full backup they build upon
+	Sequence  int       `json:"sequence,omitempty"`  // For incremental backups, position in the chain after the full backup (starting at 1)
+	Encrypted bool      `json:"encrypted,omitempty"` // If true, the payload is encrypted with a passphrase (age/scrypt)
+}
+
+// Incremental returns true if the backup only contains the keys changed since the previous one
+func (h BackupHeader) Incremental() bool {
+	return h.Kind == BackupIncremental
+}
+
+// WriteBackupArchive writes a zstd-compressed full backup of the database to w, preceded by a header describing it.
+// If passphrase is not empty, the payload is encrypted with it.
+func WriteBackupArchive(w io.Writer, driver DatabaseDriver, driverName string, passphrase string) (BackupHeader, error) {
+	return writeArchive(w, driver, BackupHeader{
+		Driver: driverName,
+		Kind:   BackupFull,
+	}, nil, passphrase)
+}
+
+// WriteIncrementalArchive writes a backup archive only containing the given keys, on top of the full backup named base.
+// Keys that don't exist anymore are saved with an empty value.
+func WriteIncrementalArchive(w io.Writer, driver DatabaseDriver, driverName string, base string, sequence int, changed map[string]bool, passphrase string) (BackupHeader, error) {
+	return writeArchive(w, driver, BackupHeader{
+		Driver:   driverName,
+		Kind:     BackupIncremental,
+		Base:     base,
+		Sequence: sequence,
+	}, changed, passphrase)
+}
+
+// writeArchive writes a backup archive of every key in the database, or only the ones in filter if it's not nil.
+// The payload is spooled to a temporary file first, since the header needs its checksum and key count.
+func writeArchive(w io.Writer, driver DatabaseDriver, header BackupHeader, filter map[string]bool, passphrase string) (BackupHeader, error) {
+	header.Time = time.Now()
+	header.Encrypted = passphrase != ""
+
+	spool, err := os.CreateTemp("", "stul-backup-*")
+	if err != nil {
+		return header, fmt.Errorf("could not create temporary file: %w", err)
+	}
+	defer func() {
+		_ = spool.Close()
+		_ = os.Remove(spool.Name())
+	}()
+
+	// Compress first, encrypted data doesn't compress
+	var payload io.WriteCloser = nopWriteCloser{spool}
+	if header.Encrypted {
+		recipient, err := age.NewScryptRecipient(passphrase)
+		if err != nil {
+			return header, err
+		}
+		payload, err = age.Encrypt(spool, recipient)
+		if err != nil {
+			return header, fmt.Errorf("could not encrypt backup: %w", err)
+		}
+	}
+	encoder, err := zstd.NewWriter(payload)
+	if err != nil {
+		return header, err
+	}
+	hash := sha256.New()
+	err = writeEntries(io.MultiWriter(encoder, hash), func(fn func(key, value string) error) error {
+		// Only incremental backups need to know which keys were found, full ones must not hold the whole keyspace
+		var seen map[string]bool
+		if filter != nil {
+			seen = make(map[string]bool, len(filter))
+		}
+		err := driver.Iterate(func(key, value string) error {
+			if key == "stul-meta/version" {
+				header.Version = value
+			}
+			if filter != nil {
+				if !filter[key] {
+					return nil
+				}
+				seen[key] = true
+			}
+			header.Keys++
+			return fn(key, value)
+		})
+		if err != nil {
+			return err
+		}
+		// Changed keys that we didn't find were removed
+		for key := range filter {
+			if seen[key] {
+				continue
+			}
+			header.Keys++
+			if err := fn(key, ""); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		_ = encoder.Close()
+		return header, fmt.Errorf("could not write backup: %w", err)
+	}
+	if err = encoder.Close(); err != nil {
+		return header, fmt.Errorf("could not compress backup: %w", err)
+	}
+	if err = payload.Close(); err != nil {
+		return header, fmt.Errorf("could not encrypt backup: %w", err)
+	}
+	header.Checksum = hex.EncodeToString(hash.Sum(nil))
+
+	// Write header and then copy the compressed payload after it
+	if err = writeArchiveHeader(w, header); err != nil {
+		return header, err
+	}
+	if _, err = spool.Seek(0, io.SeekStart); err != nil {
+		return header, err
+	}
+	if _, err = io.Copy(w, spool); err != nil {
+		return header, fmt.Errorf("could not write backup: %w", err)
+	}
+
+	return header, nil
+}
+
+func writeArchiveHeader(w io.Writer, header BackupHeader) error {
+	encoded, err := json.Marshal(header)
+	if err != nil {
+		return err
+	}
+	var buf bytes.Buffer
+	buf.Write(archiveMagic)
+	_ = binary.Write(&buf, binary.LittleEndian, uint32(len(encoded)))
+	buf.Write(encoded)
+	_, err = w.Write(buf.Bytes())
+	return err
+}
+
+// IsBackupArchive checks if the stream starts with the backup archive signature, without consuming it
+func IsBackupArchive(r *bufio.Reader) bool {
+	magic, err := r.Peek(len(archiveMagic))
+	return err == nil && bytes.Equal(magic, archiveMagic)
+}
+
+// ReadBackupHeader reads the header of a backup archive, leaving r at the start of the compressed payload
+func ReadBackupHeader(r io.Reader) (BackupHeader, error) {
+	var header BackupHeader
+
+	magic := make([]byte, len(archiveMagic))
+	if _, err := io.ReadFull(r, magic); err != nil || !bytes.Equal(magic, archiveMagic) {
+		return header, ErrNotAnArchive
+	}
+
+	var size uint32
+	if err := binary.Read(r, binary.LittleEndian, &size); err != nil {
+		return header, fmt.Errorf("%w: could not read header", ErrCorruptedBackup)
+	}
+	if size > maxHeaderSize {
+		return header, fmt.Errorf("%w: header is too large", ErrCorruptedBackup)
+	}
+	encoded := make([]byte, size)
+	if _, err := io.ReadFull(r, encoded); err != nil {
+		return header, fmt.Errorf("%w: could not read header", ErrCorruptedBackup)
+	}
+	if err := json.Unmarshal(encoded, &header); err != nil {
+		return header, fmt.Errorf("%w: invalid header: %s", ErrCorruptedBackup, err.Error())
+	}
+
+	return header, nil
+}
+
+// OpenBackupArchive reads the header of a backup archive and returns a reader for the decompressed payload.
+// The passphrase is only needed for encrypted backups. The payload is not verified, use VerifyBackupArchive beforehand.
+func OpenBackupArchive(r io.Reader, passphrase string) (BackupHeader, io.ReadCloser, error) {
+	header, err := ReadBackupHeader(r)
+	if err != nil {
+		return header, nil, err
+	}
+
+	if header.Encrypted {
+		if passphrase == "" {
+			return header, nil, ErrPassphraseRequired
+		}
+		identity, err := age.NewScryptIdentity(passphrase)
+		if err != nil {
+			return header, nil, err
+		}
+		r, err = age.Decrypt(r, identity)
+		if err != nil {
+			var noMatch *age.NoIdentityMatchError
+			if errors.As(err, &noMatch) {
+				return header, nil, ErrWrongPassphrase
+			}
+			return header, nil, fmt.Errorf("%w: %s", ErrCorruptedBackup, err.Error())
+		}
+	}
+
+	decoder, err := zstd.NewReader(r)
+	if err != nil {
+		return header, nil, fmt.Errorf("%w: %s", ErrCorruptedBackup, err.Error())
+	}
+	return header, decoder.IOReadCloser(), nil
+}
+
+// VerifyLegacyBackup reads a whole backup in the old uncompressed format and checks it can be decoded, returning how
+// many keys it has. Old backups have no checksum, so this only catches truncated or malformed files.
+func VerifyLegacyBackup(r io.Reader) (int, error) {
+	keys := 0
+	err := readEntries(r, func(string, string) error {
+		keys++
+		return nil
+	})
+	if err != nil {
+		return keys, fmt.Errorf("%w: %s", ErrCorruptedBackup, err.Error())
+	}
+	return keys, nil
+}
+
+// VerifyBackupArchive reads a whole backup archive and checks its payload against the checksum and key count in the header
+func VerifyBackupArchive(r io.Reader, passphrase string) (BackupHeader, error) {
+	header, payload, err := OpenBackupArchive(r, passphrase)
+	if err != nil {
+		return header, err
+	}
+	defer payload.Close()
+
+	hash := sha256.New()
+	tee := io.TeeReader(payload, hash)
+	keys := 0
+	err = readEntries(tee, func(string, string) error {
+		keys++
+		return nil
+	})
+	if err == nil {
+		// Hash anything left after the object (eg. trailing newline)
+		_, err = io.Copy(io.Discard, tee)
+	}
+	if err != nil {
+		return header, fmt.Errorf("%w: %s", ErrCorruptedBackup, err.Error())
+	}
+
+	if checksum := hex.EncodeToString(hash.Sum(nil)); checksum != header.Checksum {
+		return header, fmt.Errorf("%w: checksum mismatch (expected %s, got %s)", ErrCorruptedBackup, header.Checksum, checksum)
+	}
+	if keys != header.Keys {
+		return header, fmt.Errorf("%w: expected %d keys, found %d", ErrCorruptedBackup, header.Keys, keys)
+	}
+
+	return header, nil
+}
+
+type nopWriteCloser struct {
+	io.Writer
+}
+
+func (nopWriteCloser) Close() error { return nil }
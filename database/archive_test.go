@@ -0,0 +1,108 @@
+package database
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+)
+
+func writeTestArchive(t *testing.T, driver DatabaseDriver, passphrase string) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	if _, err := WriteBackupArchive(&buf, driver, "memory", passphrase); err != nil {
+		t.Fatalf("could not write backup: %s", err)
+	}
+	return buf.Bytes()
+}
+
+func TestVerifyBackupArchive(t *testing.T) {
+	driver := newTestMemoryDatabase(t)
+	setTestKeys(t, driver, map[string]string{"stul-meta/version": "v1.2.3", "a": "1", "b": "2"})
+	archive := writeTestArchive(t, driver, "")
+
+	header, err := VerifyBackupArchive(bytes.NewReader(archive), "")
+	if err != nil {
+		t.Fatalf("valid backup failed verification: %s", err)
+	}
+	if header.Keys != 3 || header.Version != "v1.2.3" || header.Incremental() {
+		t.Fatalf("unexpected header: %+v", header)
+	}
+
+	tests := []struct {
+		name    string
+		archive []byte
+		err     error
+	}{
+		{"not an archive", []byte(`{"a":"1"}`), ErrNotAnArchive},
+		{"truncated", archive[:len(archive)-8], ErrCorruptedBackup},
+		{"flipped byte", func() []byte {
+			damaged := append([]byte(nil), archive...)
+			damaged[len(damaged)-4] ^= 0xff
+			return damaged
+		}(), ErrCorruptedBackup},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if _, err := VerifyBackupArchive(bytes.NewReader(test.archive), ""); !errors.Is(err, test.err) {
+				t.Fatalf("expected %v, got %v", test.err, err)
+			}
+		})
+	}
+}
+
+func TestVerifyLegacyBackup(t *testing.T) {
+	tests := []struct {
+		name   string
+		backup string
+		keys   int
+		err    error
+	}{
+		{"valid", `{"a":"1","b":"2"}`, 2, nil},
+		{"no keys", `{}`, 0, nil},
+		{"truncated", `{"a":"1","b":"`, 0, ErrCorruptedBackup},
+		{"empty file", ``, 0, ErrCorruptedBackup},
+		{"not an object", `["a","1"]`, 0, ErrCorruptedBackup},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			keys, err := VerifyLegacyBackup(bytes.NewReader([]byte(test.backup)))
+			if !errors.Is(err, test.err) {
+				t.Fatalf("expected %v, got %v", test.err, err)
+			}
+			if err == nil && keys != test.keys {
+				t.Fatalf("found %d keys, expected %d", keys, test.keys)
+			}
+		})
+	}
+}
+
+func TestEncryptedBackupArchive(t *testing.T) {
+	driver := newTestMemoryDatabase(t)
+	setTestKeys(t, driver, map[string]string{"a": "1"})
+	archive := writeTestArchive(t, driver, "hunter2")
+
+	if bytes.Contains(archive, []byte(`"a"`)) {
+		t.Fatal("encrypted backup contains plaintext keys")
+	}
+
+	tests := []struct {
+		name       string
+		passphrase string
+		err        error
+	}{
+		{"no passphrase", "", ErrPassphraseRequired},
+		{"wrong passphrase", "hunter3", ErrWrongPassphrase},
+		{"right passphrase", "hunter2", nil},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			header, err := VerifyBackupArchive(bytes.NewReader(archive), test.passphrase)
+			if !errors.Is(err, test.err) {
+				t.Fatalf("expected %v, got %v", test.err, err)
+			}
+			if !header.Encrypted {
+				t.Fatal("header does not say the backup is encrypted")
+			}
+		})
+	}
+}
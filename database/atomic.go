@@ -0,0 +1,140 @@
+package database
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	kv "github.com/strimertul/kilovolt/v9"
+	"go.uber.org/zap"
+)
+
+// ExpectPrefix makes a kset-bulk request conditional: the value written to "<ExpectPrefix><key>" is the value key
+// must currently have ("" for missing keys) for the request to be applied, eg. the request is only applied if
+// "my-overlay/state" is "idle" when it includes "stul-meta/expect/my-overlay/state": "idle".
+// Conditions are checked under the same lock as every other write, and they are never stored.
+const ExpectPrefix = "stul-meta/expect/"
+
+// RPC keys for atomic operations, for kilovolt clients (eg. overlays) that need them
+const (
+	IncrementRPC      = "stul-meta/@increment"
+	CompareAndSwapRPC = "stul-meta/@compare-and-swap"
+)
+
+// IncrementRequest is the payload for IncrementRPC
+type IncrementRequest struct {
+	Key   string `json:"key"`
+	Delta int64  `json:"delta"`
+}
+
+// CompareAndSwapRequest is the payload for CompareAndSwapRPC
+type CompareAndSwapRequest struct {
+	Key string `json:"key"`
+	Old string `json:"old"`
+	New string `json:"new"`
+}
+
+// Increment atomically adds delta to a key holding an integer and returns the new value.
+// Missing or empty keys count as 0.
+func (mod *LocalDBClient) Increment(key string, delta int64) (int64, error) {
+	var value int64
+	err := mod.Transaction(func(tx *Transaction) error {
+		current, err := tx.Get(key)
+		if err != nil {
+			return err
+		}
+		value = 0
+		if current != "" {
+			value, err = strconv.ParseInt(current, 10, 64)
+			if err != nil {
+				return fmt.Errorf("%s does not contain an integer: %w", key, err)
+			}
+		}
+		value += delta
+		tx.Put(key, strconv.FormatInt(value, 10))
+		return nil
+	})
+	return value, err
+}
+
+// CompareAndSwap sets key to newValue only if its current value is oldValue ("" for missing keys),
+// returning whether the value was changed
+func (mod *LocalDBClient) CompareAndSwap(key string, oldValue string, newValue string) (bool, error) {
+	err := mod.commit(&Transaction{
+		db:         mod,
+		conditions: map[string]string{key: oldValue},
+		writes:     map[string]string{key: newValue},
+	})
+	if errors.Is(err, ErrConditionFailed) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// ServeAtomicRPC makes Increment and CompareAndSwap available to kilovolt clients through IncrementRPC and
+// CompareAndSwapRPC. Only one client per hub should serve them.
+func (mod *LocalDBClient) ServeAtomicRPC() error {
+	err, _ := mod.SubscribeKey(IncrementRPC, func(value string) {
+		var request IncrementRequest
+		if err := json.UnmarshalFromString(value, &request); err != nil {
+			mod.logger.Error("invalid increment request", zap.Error(err))
+			return
+		}
+		if _, err := mod.Increment(request.Key, request.Delta); err != nil {
+			mod.logger.Error("could not increment key", zap.String("key", request.Key), zap.Error(err))
+		}
+	})
+	if err != nil {
+		return err
+	}
+
+	err, _ = mod.SubscribeKey(CompareAndSwapRPC, func(value string) {
+		var request CompareAndSwapRequest
+		if err := json.UnmarshalFromString(value, &request); err != nil {
+			mod.logger.Error("invalid compare-and-swap request", zap.Error(err))
+			return
+		}
+		if _, err := mod.CompareAndSwap(request.Key, request.Old, request.New); err != nil {
+			mod.logger.Error("could not swap key", zap.String("key", request.Key), zap.Error(err))
+		}
+	})
+	return err
+}
+
+// checkConditions removes the conditions (see ExpectPrefix) from a write, returning ErrConditionFailed if any of
+// them doesn't hold. Must be called with the lock held, so nothing can change between the check and the write.
+func (b *ttlBackend) checkConditions(kvs map[string]string, now time.Time) (map[string]string, error) {
+	var writes map[string]string
+	for key := range kvs {
+		if strings.HasPrefix(key, ExpectPrefix) {
+			writes = make(map[string]string, len(kvs))
+			break
+		}
+	}
+	if writes == nil {
+		return kvs, nil
+	}
+
+	for key, value := range kvs {
+		if !strings.HasPrefix(key, ExpectPrefix) {
+			writes[key] = value
+			continue
+		}
+		target := key[len(ExpectPrefix):]
+		current := ""
+		if !b.expired(target, now) {
+			var err error
+			current, err = b.Driver.Get(target)
+			if err != nil && !errors.Is(err, kv.ErrorKeyNotFound) {
+				return nil, err
+			}
+		}
+		// Clients only ever see secrets masked, so that's what they expect
+		if MaskSecret(target, current) != value {
+			return nil, fmt.Errorf("%w: %s was changed", ErrConditionFailed, target)
+		}
+	}
+	return writes, nil
+}
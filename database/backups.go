@@ -0,0 +1,261 @@
+package database
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// BackupInfo describes a backup archive stored on disk
+type BackupInfo struct {
+	Name   string
+	Path   string
+	Size   int64
+	Header BackupHeader
+}
+
+// ReadBackupInfo reads the header of a backup archive
+func ReadBackupInfo(path string) (BackupInfo, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return BackupInfo{}, err
+	}
+	defer file.Close()
+
+	stat, err := file.Stat()
+	if err != nil {
+		return BackupInfo{}, err
+	}
+
+	header, err := ReadBackupHeader(file)
+	if err != nil {
+		return BackupInfo{}, err
+	}
+
+	return BackupInfo{
+		Name:   filepath.Base(path),
+		Path:   path,
+		Size:   stat.Size(),
+		Header: header,
+	}, nil
+}
+
+// ListBackups returns every backup archive in a directory, oldest first.
+// Files that aren't backup archives (including backups in the old format) are skipped.
+func ListBackups(directory string) ([]BackupInfo, error) {
+	entries, err := os.ReadDir(directory)
+	if err != nil {
+		return nil, err
+	}
+
+	var backups []BackupInfo
+	for _, entry := range entries {
+		if entry.IsDir() {
+			continue
+		}
+		info, err := ReadBackupInfo(filepath.Join(directory, entry.Name()))
+		if err != nil {
+			continue
+		}
+		backups = append(backups, info)
+	}
+
+	sort.Slice(backups, func(i, j int) bool {
+		return backups[i].Header.Time.Before(backups[j].Header.Time)
+	})
+	return backups, nil
+}
+
+// BackupChain returns the backups needed to restore the named backup: the full snapshot it builds upon,
+// followed by every increment up to and including it
+func BackupChain(directory string, name string) ([]BackupInfo, error) {
+	target, err := ReadBackupInfo(filepath.Join(directory, name))
+	if err != nil {
+		return nil, err
+	}
+	if !target.Header.Incremental() {
+		return []BackupInfo{target}, nil
+	}
+
+	base, err := ReadBackupInfo(filepath.Join(directory, target.Header.Base))
+	if err != nil {
+		return nil, fmt.Errorf("could not read full backup %s: %w", target.Header.Base, err)
+	}
+
+	backups, err := ListBackups(directory)
+	if err != nil {
+		return nil, err
+	}
+	increments := make(map[int]BackupInfo)
+	for _, backup := range backups {
+		if backup.Header.Incremental() && backup.Header.Base == target.Header.Base {
+			increments[backup.Header.Sequence] = backup
+		}
+	}
+
+	chain := []BackupInfo{base}
+	for sequence := 1; sequence <= target.Header.Sequence; sequence++ {
+		increment, ok := increments[sequence]
+		if !ok {
+			return nil, fmt.Errorf("incremental backup #%d on top of %s is missing", sequence, target.Header.Base)
+		}
+		chain = append(chain, increment)
+	}
+
+	return chain, nil
+}
+
+// RestoreBackups checks every backup in the list and then restores them in order, the first one must be a full backup.
+// The database is cleared first, so it ends up exactly as it was when the last backup was taken.
+// The passphrase is only needed for encrypted backups.
+func RestoreBackups(driver DatabaseDriver, backups []BackupInfo, passphrase string) error {
+	if len(backups) < 1 {
+		return errors.New("no backups to restore")
+	}
+	if backups[0].Header.Incremental() {
+		return fmt.Errorf("%s is an incremental backup, restoring needs its full backup first", backups[0].Name)
+	}
+
+	// Verify everything first, so we don't stop halfway through
+	for _, backup := range backups {
+		if err := verifyBackupFile(backup.Path, passphrase); err != nil {
+			return fmt.Errorf("%s: %w", backup.Name, err)
+		}
+	}
+
+	if err := driver.Clear(); err != nil {
+		return fmt.Errorf("could not clear database: %w", err)
+	}
+	for _, backup := range backups {
+		if err := restoreBackupFile(driver, backup.Path, passphrase); err != nil {
+			return fmt.Errorf("%s: %w", backup.Name, err)
+		}
+	}
+
+	return nil
+}
+
+func verifyBackupFile(path string, passphrase string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	_, err = VerifyBackupArchive(file, passphrase)
+	return err
+}
+
+func restoreBackupFile(driver DatabaseDriver, path string, passphrase string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	_, payload, err := OpenBackupArchive(file, passphrase)
+	if err != nil {
+		return err
+	}
+	defer payload.Close()
+
+	return driver.Restore(payload)
+}
+
+// FindBackupAt returns the most recent backup taken at or before the given time
+func FindBackupAt(directory string, at time.Time) (BackupInfo, error) {
+	backups, err := ListBackups(directory)
+	if err != nil {
+		return BackupInfo{}, err
+	}
+
+	found := -1
+	for index, backup := range backups {
+		if backup.Header.Time.After(at) {
+			break
+		}
+		found = index
+	}
+	if found < 0 {
+		return BackupInfo{}, fmt.Errorf("no backups found before %s", at.Format(time.RFC3339))
+	}
+	return backups[found], nil
+}
+
+// ReadBackupState returns the database contents at the time of the named backup, replaying its chain if it's
+// incremental. Only keys starting with prefix are kept. The passphrase is only needed for encrypted backups.
+func ReadBackupState(directory string, name string, prefix string, passphrase string) (map[string]string, error) {
+	chain, err := BackupChain(directory, name)
+	if err != nil {
+		return nil, err
+	}
+
+	state := make(map[string]string)
+	for _, backup := range chain {
+		err = readBackupFile(backup.Path, passphrase, func(key, value string) error {
+			if !strings.HasPrefix(key, prefix) {
+				return nil
+			}
+			// Incremental backups save removed keys as empty
+			if value == "" {
+				delete(state, key)
+			} else {
+				state[key] = value
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", backup.Name, err)
+		}
+	}
+	return state, nil
+}
+
+// BackupDiff lists the keys that differ between two database states
+type BackupDiff struct {
+	Added   []string
+	Removed []string
+	Changed []string
+}
+
+// DiffStates compares two database states, keys in each list are sorted
+func DiffStates(before map[string]string, after map[string]string) BackupDiff {
+	var diff BackupDiff
+	for key, value := range after {
+		previous, ok := before[key]
+		if !ok {
+			diff.Added = append(diff.Added, key)
+		} else if previous != value {
+			diff.Changed = append(diff.Changed, key)
+		}
+	}
+	for key := range before {
+		if _, ok := after[key]; !ok {
+			diff.Removed = append(diff.Removed, key)
+		}
+	}
+	sort.Strings(diff.Added)
+	sort.Strings(diff.Removed)
+	sort.Strings(diff.Changed)
+	return diff
+}
+
+func readBackupFile(path string, passphrase string, fn func(key, value string) error) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	_, payload, err := OpenBackupArchive(file, passphrase)
+	if err != nil {
+		return err
+	}
+	defer payload.Close()
+
+	return readEntries(payload, fn)
+}
@@ -0,0 +1,177 @@
+package database
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+func newTestMemoryDatabase(t *testing.T) *MemoryDatabase {
+	t.Helper()
+	driver, err := NewInMemory(zap.NewNop())
+	if err != nil {
+		t.Fatalf("could not create database: %s", err)
+	}
+	t.Cleanup(func() {
+		_ = driver.Close()
+	})
+	return driver
+}
+
+func setTestKeys(t *testing.T, driver *MemoryDatabase, entries map[string]string) {
+	t.Helper()
+	if err := driver.backend.SetBulk(entries); err != nil {
+		t.Fatalf("could not write keys: %s", err)
+	}
+}
+
+func readTestKeys(t *testing.T, driver DatabaseDriver) map[string]string {
+	t.Helper()
+	state := make(map[string]string)
+	err := driver.Iterate(func(key, value string) error {
+		state[key] = value
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("could not read keys: %s", err)
+	}
+	return state
+}
+
+func writeTestBackup(t *testing.T, path string, write func(file *os.File) (BackupHeader, error)) {
+	t.Helper()
+	file, err := os.Create(path)
+	if err != nil {
+		t.Fatalf("could not create backup: %s", err)
+	}
+	defer file.Close()
+	if _, err = write(file); err != nil {
+		t.Fatalf("could not write backup: %s", err)
+	}
+}
+
+func TestRestoreBackupChain(t *testing.T) {
+	directory := t.TempDir()
+	driver := newTestMemoryDatabase(t)
+
+	setTestKeys(t, driver, map[string]string{"a": "1", "b": "2", "c": "3"})
+	writeTestBackup(t, filepath.Join(directory, "full.db"), func(file *os.File) (BackupHeader, error) {
+		return WriteBackupArchive(file, driver, "memory", "")
+	})
+
+	// Change a, remove b, add d
+	setTestKeys(t, driver, map[string]string{"a": "10", "d": "4"})
+	if err := driver.backend.Delete("b"); err != nil {
+		t.Fatal(err)
+	}
+	changed := map[string]bool{"a": true, "b": true, "d": true}
+	writeTestBackup(t, filepath.Join(directory, "inc.db"), func(file *os.File) (BackupHeader, error) {
+		return WriteIncrementalArchive(file, driver, "memory", "full.db", 1, changed, "")
+	})
+	expected := map[string]string{"a": "10", "c": "3", "d": "4"}
+
+	// Keys created after the backup must not survive the restore
+	setTestKeys(t, driver, map[string]string{"e": "5", "b": "20"})
+
+	chain, err := BackupChain(directory, "inc.db")
+	if err != nil {
+		t.Fatalf("could not find backup chain: %s", err)
+	}
+	if err = RestoreBackups(driver, chain, ""); err != nil {
+		t.Fatalf("could not restore backups: %s", err)
+	}
+	if state := readTestKeys(t, driver); !reflect.DeepEqual(state, expected) {
+		t.Fatalf("restored state is %v, expected %v", state, expected)
+	}
+
+	// Replaying the chain without a database must give the same result
+	state, err := ReadBackupState(directory, "inc.db", "", "")
+	if err != nil {
+		t.Fatalf("could not read backup state: %s", err)
+	}
+	if !reflect.DeepEqual(state, expected) {
+		t.Fatalf("backup state is %v, expected %v", state, expected)
+	}
+}
+
+func TestRestoreBackupsNeedsFullBackup(t *testing.T) {
+	directory := t.TempDir()
+	driver := newTestMemoryDatabase(t)
+
+	setTestKeys(t, driver, map[string]string{"a": "1"})
+	writeTestBackup(t, filepath.Join(directory, "inc.db"), func(file *os.File) (BackupHeader, error) {
+		return WriteIncrementalArchive(file, driver, "memory", "full.db", 1, map[string]bool{"a": true}, "")
+	})
+	info, err := ReadBackupInfo(filepath.Join(directory, "inc.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err = RestoreBackups(driver, []BackupInfo{info}, ""); err == nil {
+		t.Fatal("restoring an incremental backup on its own should fail")
+	}
+	// Nothing must be cleared when refusing
+	if state := readTestKeys(t, driver); state["a"] != "1" {
+		t.Fatalf("database was changed by a refused restore: %v", state)
+	}
+}
+
+func TestFindBackupAt(t *testing.T) {
+	directory := t.TempDir()
+	driver := newTestMemoryDatabase(t)
+
+	var times []time.Time
+	for _, name := range []string{"1.db", "2.db", "3.db"} {
+		var header BackupHeader
+		writeTestBackup(t, filepath.Join(directory, name), func(file *os.File) (BackupHeader, error) {
+			var err error
+			header, err = WriteBackupArchive(file, driver, "memory", "")
+			return header, err
+		})
+		times = append(times, header.Time)
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	tests := []struct {
+		name     string
+		at       time.Time
+		expected string
+	}{
+		{"exact time", times[1], "2.db"},
+		{"between backups", times[1].Add(5 * time.Millisecond), "2.db"},
+		{"after every backup", times[2].Add(time.Hour), "3.db"},
+		{"before every backup", times[0].Add(-time.Hour), ""},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			backup, err := FindBackupAt(directory, test.at)
+			if test.expected == "" {
+				if err == nil {
+					t.Fatalf("expected no backup, got %s", backup.Name)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("could not find backup: %s", err)
+			}
+			if backup.Name != test.expected {
+				t.Fatalf("found %s, expected %s", backup.Name, test.expected)
+			}
+		})
+	}
+}
+
+func TestDiffStates(t *testing.T) {
+	before := map[string]string{"a": "1", "b": "2", "c": "3"}
+	after := map[string]string{"a": "1", "b": "20", "d": "4"}
+	diff := DiffStates(before, after)
+
+	expected := BackupDiff{Added: []string{"d"}, Removed: []string{"c"}, Changed: []string{"b"}}
+	if !reflect.DeepEqual(diff, expected) {
+		t.Fatalf("diff is %+v, expected %+v", diff, expected)
+	}
+}
@@ -0,0 +1,153 @@
+package database
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// CheckedPrefixes are the prefixes owned by strimertul, keys outside of them (eg. overlay data) are not checked
+var CheckedPrefixes = []string{"twitch/", "loyalty/", "http/", "stul-meta/"}
+
+// KeySchema describes what a key holds, so values nothing can read can be found before a module fails on them
+type KeySchema struct {
+	Key   string      // Key, or prefix of the keys if it ends with "/"
+	Value interface{} // A value of the type the key is decoded into (as JSON), nil if the key holds a plain string
+}
+
+func init() {
+	RegisterSchema(KeySchema{Key: "stul-meta/version"})
+	RegisterSchema(KeySchema{Key: SchemaVersionKey, Value: 0})
+	RegisterSchema(KeySchema{Key: ExpiryPrefix, Value: int64(0)})
+	RegisterSchema(KeySchema{Key: IncrementRPC, Value: IncrementRequest{}})
+	RegisterSchema(KeySchema{Key: CompareAndSwapRPC, Value: CompareAndSwapRequest{}})
+}
+
+var schemas = struct {
+	sync.Mutex
+	list map[string]KeySchema
+}{list: make(map[string]KeySchema)}
+
+// RegisterSchema adds the schema of a key (or prefix) to the ones checked by CheckDatabase.
+// Modules should call this in init(), next to the definition of the key.
+func RegisterSchema(schema KeySchema) {
+	schemas.Lock()
+	defer schemas.Unlock()
+	schemas.list[schema.Key] = schema
+}
+
+// schemaOf returns the schema of a key, preferring exact matches over the longest matching prefix
+func schemaOf(key string) (KeySchema, bool) {
+	schemas.Lock()
+	defer schemas.Unlock()
+	if schema, ok := schemas.list[key]; ok {
+		return schema, true
+	}
+	var found KeySchema
+	for name, schema := range schemas.list {
+		if strings.HasSuffix(name, "/") && strings.HasPrefix(key, name) && len(name) > len(found.Key) {
+			found = schema
+		}
+	}
+	return found, found.Key != ""
+}
+
+const (
+	// IssueCorrupt is a value that can't be decoded into the type its key holds
+	IssueCorrupt = "corrupt"
+	// IssueUnknown is a key strimertul doesn't know about, it might be from a newer version
+	IssueUnknown = "unknown"
+	// IssueEmpty is a key holding an empty string, left behind by versions that didn't really delete keys
+	IssueEmpty = "empty"
+)
+
+// CheckIssue is a problem found with a key
+type CheckIssue struct {
+	Key     string
+	Problem string // One of IssueCorrupt, IssueUnknown or IssueEmpty
+	Details string
+}
+
+// CheckReport is the outcome of a database check
+type CheckReport struct {
+	Checked int // Keys inside CheckedPrefixes
+	Skipped int // Keys outside CheckedPrefixes
+	Issues  []CheckIssue
+}
+
+// CheckDatabase walks every key inside CheckedPrefixes and validates it against its schema
+func CheckDatabase(driver DatabaseDriver) (CheckReport, error) {
+	var report CheckReport
+	secrets := driver.Secrets()
+	err := driver.Iterate(func(key, value string) error {
+		if !isChecked(key) {
+			report.Skipped++
+			return nil
+		}
+		report.Checked++
+
+		if value == "" {
+			report.Issues = append(report.Issues, CheckIssue{Key: key, Problem: IssueEmpty})
+			return nil
+		}
+		schema, ok := schemaOf(key)
+		if !ok {
+			report.Issues = append(report.Issues, CheckIssue{Key: key, Problem: IssueUnknown})
+			return nil
+		}
+		if schema.Value == nil {
+			return nil
+		}
+		if secrets != nil {
+			value = secrets.Reveal(key, value)
+		}
+		decoded := reflect.New(reflect.TypeOf(schema.Value)).Interface()
+		if err := json.UnmarshalFromString(value, decoded); err != nil {
+			report.Issues = append(report.Issues, CheckIssue{Key: key, Problem: IssueCorrupt, Details: err.Error()})
+		}
+		return nil
+	})
+	return report, err
+}
+
+// RepairDatabase fixes the issues found by CheckDatabase by deleting corrupt and empty keys, modules go back to
+// their defaults for missing keys. Unknown keys are left alone.
+func RepairDatabase(db *LocalDBClient, issues []CheckIssue) (int, error) {
+	repaired := 0
+	for _, issue := range issues {
+		if issue.Problem != IssueCorrupt && issue.Problem != IssueEmpty {
+			continue
+		}
+		if err := db.RemoveKey(issue.Key); err != nil {
+			return repaired, fmt.Errorf("could not repair %s: %w", issue.Key, err)
+		}
+		repaired++
+	}
+	return repaired, nil
+}
+
+// SortIssues orders issues by problem, then key
+func SortIssues(issues []CheckIssue) {
+	sort.Slice(issues, func(i, j int) bool {
+		if issues[i].Problem != issues[j].Problem {
+			return issues[i].Problem < issues[j].Problem
+		}
+		return issues[i].Key < issues[j].Key
+	})
+}
+
+func isChecked(key string) bool {
+	for _, prefix := range CheckedPrefixes {
+		if strings.HasPrefix(key, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// BackupBeforeRepair takes a full backup in the backup directory (named *-pre-repair.db), returning its path
+func BackupBeforeRepair(driver DatabaseDriver, options BackupOptions) (string, error) {
+	return backupBefore(driver, options, "repair")
+}
@@ -0,0 +1,23 @@
+package database
+
+// PurgeEmptyKeys deletes every key with an empty value, left behind by versions of strimertul that
+// "deleted" keys by emptying them. Returns how many keys were deleted.
+func PurgeEmptyKeys(driver DatabaseDriver, db *LocalDBClient) (int, error) {
+	var empty []string
+	err := driver.Iterate(func(key, value string) error {
+		if value == "" {
+			empty = append(empty, key)
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	for index, key := range empty {
+		if err = db.RemoveKey(key); err != nil {
+			return index, err
+		}
+	}
+	return len(empty), nil
+}
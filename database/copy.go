@@ -0,0 +1,80 @@
+package database
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+var (
+	// ErrDestinationNotEmpty is returned when copying to a database that already has data in it
+	ErrDestinationNotEmpty = errors.New("destination database is not empty")
+
+	// ErrVerificationFailed is returned when the copied data does not match the source
+	ErrVerificationFailed = errors.New("destination does not match source")
+)
+
+// Summary describes the contents of a database
+type Summary struct {
+	Keys     int
+	Checksum []byte
+}
+
+// Summarize counts the keys of a database and computes a checksum of its contents
+func Summarize(driver DatabaseDriver) (Summary, error) {
+	hash := sha256.New()
+	count := 0
+	err := driver.Iterate(func(key, value string) error {
+		// Length-prefix both fields so different key/value splits can't produce the same hash
+		_ = binary.Write(hash, binary.LittleEndian, uint64(len(key)))
+		hash.Write([]byte(key))
+		_ = binary.Write(hash, binary.LittleEndian, uint64(len(value)))
+		hash.Write([]byte(value))
+		count++
+		return nil
+	})
+	if err != nil {
+		return Summary{}, err
+	}
+	return Summary{Keys: count, Checksum: hash.Sum(nil)}, nil
+}
+
+// CopyDatabase streams every key from source into destination, which must be empty, and then checks that
+// both databases have the same content
+func CopyDatabase(source DatabaseDriver, destination DatabaseDriver) (Summary, error) {
+	existing, err := Summarize(destination)
+	if err != nil {
+		return Summary{}, fmt.Errorf("could not read destination: %w", err)
+	}
+	if existing.Keys > 0 {
+		return Summary{}, ErrDestinationNotEmpty
+	}
+
+	reader, writer := io.Pipe()
+	go func() {
+		_ = writer.CloseWithError(source.Backup(writer))
+	}()
+	err = destination.Import(reader)
+	// Unblock the exporting side if the import stopped early
+	_ = reader.CloseWithError(err)
+	if err != nil {
+		return Summary{}, fmt.Errorf("could not copy data: %w", err)
+	}
+
+	sourceSummary, err := Summarize(source)
+	if err != nil {
+		return Summary{}, fmt.Errorf("could not read source: %w", err)
+	}
+	destinationSummary, err := Summarize(destination)
+	if err != nil {
+		return Summary{}, fmt.Errorf("could not read destination: %w", err)
+	}
+	if sourceSummary.Keys != destinationSummary.Keys || !bytes.Equal(sourceSummary.Checksum, destinationSummary.Checksum) {
+		return Summary{}, fmt.Errorf("%w (source has %d keys, destination has %d)", ErrVerificationFailed, sourceSummary.Keys, destinationSummary.Keys)
+	}
+
+	return sourceSummary, nil
+}
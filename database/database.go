@@ -3,6 +3,7 @@ package database
 import (
 	"errors"
 	"fmt"
+	"time"
 
 	jsoniter "github.com/json-iterator/go"
 	kv "github.com/strimertul/kilovolt/v9"
@@ -22,9 +23,10 @@ var (
 )
 
 type LocalDBClient struct {
-	client *kv.LocalClient
-	hub    *kv.Hub
-	logger *zap.Logger
+	client  *kv.LocalClient
+	hub     *kv.Hub
+	secrets *SecretStore
+	logger  *zap.Logger
 }
 
 type KvPair struct {
@@ -32,7 +34,7 @@ type KvPair struct {
 	Data string
 }
 
-func NewLocalClient(hub *kv.Hub, logger *zap.Logger) (*LocalDBClient, error) {
+func NewLocalClient(hub *kv.Hub, secrets *SecretStore, logger *zap.Logger) (*LocalDBClient, error) {
 	// Create local client
 	localClient := kv.NewLocalClient(kv.ClientOptions{}, logger)
 
@@ -48,9 +50,10 @@ func NewLocalClient(hub *kv.Hub, logger *zap.Logger) (*LocalDBClient, error) {
 	}
 
 	return &LocalDBClient{
-		client: localClient,
-		hub:    hub,
-		logger: logger,
+		client:  localClient,
+		hub:     hub,
+		secrets: secrets,
+		logger:  logger,
 	}, nil
 }
 
@@ -58,6 +61,11 @@ func (mod *LocalDBClient) Hub() *kv.Hub {
 	return mod.hub
 }
 
+// Secrets returns the store used to encrypt secrets, nil if secrets are stored in clear
+func (mod *LocalDBClient) Secrets() *SecretStore {
+	return mod.secrets
+}
+
 func (mod *LocalDBClient) Close() error {
 	mod.hub.RemoveClient(mod.client)
 	return nil
@@ -76,6 +84,15 @@ func (mod *LocalDBClient) PutKey(key string, data string) error {
 	return err
 }
 
+// PutKeyWithTTL writes a key that is deleted once ttl has passed, writing the key again without a TTL makes it permanent
+func (mod *LocalDBClient) PutKeyWithTTL(key string, data string, ttl time.Duration) error {
+	_, err := mod.makeRequest(kv.CmdWriteBulk, map[string]interface{}{
+		key:                data,
+		ExpiryPrefix + key: formatExpiry(time.Now().Add(ttl)),
+	})
+	return err
+}
+
 func (mod *LocalDBClient) SubscribePrefix(fn kv.SubscriptionCallback, prefixes ...string) (err error, cancelFn CancelFunc) {
 	var ids []int64
 	for _, prefix := range prefixes {
@@ -119,6 +136,34 @@ func (mod *LocalDBClient) GetJSON(key string, dst interface{}) error {
 	return json.Unmarshal([]byte(res), dst)
 }
 
+// GetSecret returns the value of a key with its secrets (see RegisterSecret) decrypted.
+// Reads through kilovolt (eg. GetKey) only return masked secrets.
+func (mod *LocalDBClient) GetSecret(key string) (string, error) {
+	if mod.secrets == nil || mod.secrets.backend == nil {
+		return mod.GetKey(key)
+	}
+	value, err := mod.secrets.backend.Get(key)
+	if err != nil {
+		if errors.Is(err, kv.ErrorKeyNotFound) {
+			return "", nil
+		}
+		return "", err
+	}
+	return mod.secrets.Reveal(key, value), nil
+}
+
+// GetSecretJSON is like GetJSON but with secrets decrypted, only use it for values that are never sent back to clients
+func (mod *LocalDBClient) GetSecretJSON(key string, dst interface{}) error {
+	res, err := mod.GetSecret(key)
+	if err != nil {
+		return err
+	}
+	if res == "" {
+		return ErrEmptyKey
+	}
+	return json.Unmarshal([]byte(res), dst)
+}
+
 func (mod *LocalDBClient) GetAll(prefix string) (map[string]string, error) {
 	res, err := mod.makeRequest(kv.CmdReadPrefix, map[string]interface{}{"prefix": prefix})
 	if err != nil {
@@ -132,6 +177,34 @@ func (mod *LocalDBClient) GetAll(prefix string) (map[string]string, error) {
 	return out, nil
 }
 
+// GetKeys reads multiple keys at once, missing keys are returned as empty strings
+func (mod *LocalDBClient) GetKeys(keys []string) (map[string]string, error) {
+	res, err := mod.makeRequest(kv.CmdReadBulk, map[string]interface{}{"keys": keys})
+	if err != nil {
+		return nil, err
+	}
+
+	out := make(map[string]string)
+	for key, value := range res.Data.(map[string]interface{}) {
+		out[key] = value.(string)
+	}
+	return out, nil
+}
+
+// ListKeys returns the name of every key starting with prefix
+func (mod *LocalDBClient) ListKeys(prefix string) ([]string, error) {
+	res, err := mod.makeRequest(kv.CmdListKeys, map[string]interface{}{"prefix": prefix})
+	if err != nil {
+		return nil, err
+	}
+
+	var out []string
+	for _, key := range res.Data.([]interface{}) {
+		out = append(out, key.(string))
+	}
+	return out, nil
+}
+
 func (mod *LocalDBClient) PutJSON(key string, data interface{}) error {
 	byt, err := json.Marshal(data)
 	if err != nil {
@@ -141,6 +214,15 @@ func (mod *LocalDBClient) PutJSON(key string, data interface{}) error {
 	return mod.PutKey(key, string(byt))
 }
 
+func (mod *LocalDBClient) PutJSONWithTTL(key string, data interface{}, ttl time.Duration) error {
+	byt, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+
+	return mod.PutKeyWithTTL(key, string(byt), ttl)
+}
+
 func (mod *LocalDBClient) PutJSONBulk(kvs map[string]interface{}) error {
 	encoded := make(map[string]interface{})
 	for k, v := range kvs {
@@ -150,17 +232,23 @@ func (mod *LocalDBClient) PutJSONBulk(kvs map[string]interface{}) error {
 		}
 		encoded[k] = string(byt)
 	}
-	_, chn := mod.client.MakeRequest(kv.CmdWriteBulk, encoded)
-	_, err := getResponse(<-chn)
+	_, err := mod.makeRequest(kv.CmdWriteBulk, encoded)
 	return err
 }
 
+// RemoveKey deletes a key, subscribers are notified with an empty value
 func (mod *LocalDBClient) RemoveKey(key string) error {
-	// TODO
-	return mod.PutKey(key, "")
+	_, err := mod.makeRequest(kv.CmdRemoveKey, map[string]interface{}{"key": key})
+	return err
 }
 
 func (mod *LocalDBClient) makeRequest(cmd string, data map[string]interface{}) (kv.Response, error) {
+	// Encrypt secrets here, the hub notifies subscribers with the values as they were sent
+	if mod.secrets != nil {
+		if _, err := mod.secrets.sealRequestData(cmd, data); err != nil {
+			return kv.Response{}, err
+		}
+	}
 	req, chn := mod.client.MakeRequest(cmd, data)
 	mod.hub.SendMessage(req)
 	return getResponse(<-chn)
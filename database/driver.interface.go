@@ -16,22 +16,43 @@ import (
 // DatabaseDriver is a driver wrapping a supported database
 type DatabaseDriver interface {
 	Hub() *kv.Hub
+	// Secrets returns the store used to encrypt credentials saved in the database
+	Secrets() *SecretStore
 	Close() error
-	Import(map[string]string) error
+	Import(io.Reader) error
 	Export(io.Writer) error
+	// Restore writes the entries of a backup on top of what's stored, keys with an empty value
+	// (how incremental backups record removed keys) are deleted
 	Restore(io.Reader) error
 	Backup(io.Writer) error
+	// Clear removes every key, so a full backup can be restored on an empty database
+	Clear() error
+	// Iterate calls fn for every key/value pair stored in the database, in key order.
+	// Iteration stops at the first error returned by fn.
+	Iterate(fn func(key, value string) error) error
+	// Watch calls fn with every key written or removed through the hub (but not by Import, Restore or Clear),
+	// until the returned function is called. fn must not use the database.
+	Watch(fn func(key string)) CancelFunc
+}
+
+// Compacter is implemented by drivers that can reclaim the space left behind by deleted keys
+type Compacter interface {
+	Compact() error
 }
 
 type BackupOptions struct {
-	BackupDir      string
-	BackupInterval int
-	MaxBackups     int
+	DriverName      string
+	BackupDir       string
+	BackupInterval  int
+	MaxBackups      int
+	FullBackupEvery int
+	Passphrase      string
 }
 
 const databaseDefaultDriver = "pebble"
 
-func getDatabaseDriverName(ctx *cli.Context) string {
+// GetDatabaseDriverName returns the driver selected via CLI flags, autodetecting it if necessary
+func GetDatabaseDriverName(ctx *cli.Context) string {
 	driver := ctx.String("driver")
 	if driver != "auto" {
 		return driver
@@ -47,16 +68,54 @@ func getDatabaseDriverName(ctx *cli.Context) string {
 }
 
 func GetDatabaseDriver(ctx *cli.Context) (DatabaseDriver, error) {
-	name := getDatabaseDriverName(ctx)
+	name := GetDatabaseDriverName(ctx)
 	dbDirectory := ctx.String("database-dir")
 	logger := ctx.Context.Value(utils.ContextLogger).(*zap.Logger)
 
+	driver, err := OpenDriver(name, dbDirectory, logger)
+	if err != nil {
+		return nil, err
+	}
+
+	if IsPersistent(name) {
+		// Create file for autodetect
+		err = WriteDriverFile(dbDirectory, name)
+		if err != nil {
+			_ = driver.Close()
+			return nil, err
+		}
+	}
+
+	return driver, nil
+}
+
+// OpenDriver opens a database driver by name, without touching the autodetect file
+func OpenDriver(name string, directory string, logger *zap.Logger) (DatabaseDriver, error) {
 	switch name {
 	case "badger":
 		return nil, cli.Exit("Badger is not supported anymore as a database driver", 64)
 	case "pebble":
-		return NewPebble(dbDirectory, logger)
+		return NewPebble(directory, logger)
+	case "sqlite":
+		return NewSQLite(directory, logger)
+	case "memory":
+		logger.Warn("using in-memory database, no data will be saved when strimertul is closed")
+		return NewInMemory(logger)
 	default:
 		return nil, cli.Exit(fmt.Sprintf("Unknown database driver: %s", name), 64)
 	}
 }
+
+// IsPersistent returns true if the named driver saves its data to the database directory
+func IsPersistent(name string) bool {
+	return name != "memory"
+}
+
+// WriteDriverFile records which driver is used in a database directory, so it can be autodetected later
+func WriteDriverFile(directory string, name string) error {
+	err := os.WriteFile(filepath.Join(directory, "stul-driver"), []byte(name), 0o644)
+	if err != nil {
+		return fmt.Errorf("could not write driver file: %w", err)
+	}
+	return nil
+}
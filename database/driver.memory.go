@@ -0,0 +1,159 @@
+package database
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"sync"
+
+	kv "github.com/strimertul/kilovolt/v9"
+	"go.uber.org/zap"
+)
+
+// MemoryDatabase is a database driver that keeps everything in memory, nothing is ever written to disk.
+// Useful for tests and throwaway sessions.
+type MemoryDatabase struct {
+	backend *memoryBackend
+	hub     *kv.Hub
+	expiry  *ttlBackend
+	secrets *SecretStore
+	logger  *zap.Logger
+}
+
+// NewInMemory creates a new database driver instance backed by an in-memory kilovolt backend
+func NewInMemory(logger *zap.Logger) (*MemoryDatabase, error) {
+	// Nothing survives the process anyway, so the key doesn't need to either
+	secrets, err := NewEphemeralSecretStore(logger)
+	if err != nil {
+		return nil, err
+	}
+
+	m := &MemoryDatabase{
+		backend: &memoryBackend{data: kv.MakeBackend()},
+		secrets: secrets,
+		logger:  logger,
+	}
+	m.hub, m.expiry, err = newHub(m.backend, m.secrets, m.logger)
+	if err != nil {
+		return nil, fmt.Errorf("could not create hub: %w", err)
+	}
+
+	return m, nil
+}
+
+func (m *MemoryDatabase) Hub() *kv.Hub {
+	return m.hub
+}
+
+func (m *MemoryDatabase) Secrets() *SecretStore {
+	return m.secrets
+}
+
+func (m *MemoryDatabase) Watch(fn func(key string)) CancelFunc {
+	return m.expiry.watch(fn)
+}
+
+func (m *MemoryDatabase) Close() error {
+	m.expiry.Close()
+	return nil
+}
+
+func (m *MemoryDatabase) Import(file io.Reader) error {
+	return readEntriesBatched(file, m.backend.SetBulk)
+}
+
+func (m *MemoryDatabase) Export(file io.Writer) error {
+	return m.Backup(file)
+}
+
+func (m *MemoryDatabase) Restore(file io.Reader) error {
+	return restoreEntriesBatched(file, m.backend.SetBulk, m.backend.Delete)
+}
+
+func (m *MemoryDatabase) Clear() error {
+	m.backend.mu.Lock()
+	defer m.backend.mu.Unlock()
+	keys, err := m.backend.data.List("")
+	if err != nil {
+		return err
+	}
+	for _, key := range keys {
+		if err := m.backend.data.Delete(key); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (m *MemoryDatabase) Backup(file io.Writer) error {
+	return writeEntries(file, m.Iterate)
+}
+
+func (m *MemoryDatabase) Iterate(fn func(key, value string) error) error {
+	// Copy everything first so the backend isn't locked while fn runs
+	data, err := m.backend.GetPrefix("")
+	if err != nil {
+		return err
+	}
+	keys := make([]string, 0, len(data))
+	for key := range data {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		if err := fn(key, data[key]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// memoryBackend guards kilovolt's map backend, which is not safe for concurrent use,
+// so that backups can run while the hub is serving requests
+type memoryBackend struct {
+	mu   sync.RWMutex
+	data kv.Driver
+}
+
+func (b *memoryBackend) Get(key string) (string, error) {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	return b.data.Get(key)
+}
+
+func (b *memoryBackend) GetBulk(keys []string) (map[string]string, error) {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	return b.data.GetBulk(keys)
+}
+
+func (b *memoryBackend) GetPrefix(prefix string) (map[string]string, error) {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	return b.data.GetPrefix(prefix)
+}
+
+func (b *memoryBackend) Set(key string, value string) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.data.Set(key, value)
+}
+
+func (b *memoryBackend) SetBulk(kv map[string]string) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.data.SetBulk(kv)
+}
+
+func (b *memoryBackend) Delete(key string) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.data.Delete(key)
+}
+
+func (b *memoryBackend) List(prefix string) ([]string, error) {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	return b.data.List(prefix)
+}
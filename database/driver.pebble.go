@@ -3,8 +3,6 @@ package database
 import (
 	"fmt"
 	"io"
-	"os"
-	"path/filepath"
 
 	"github.com/cockroachdb/pebble"
 	kv "github.com/strimertul/kilovolt/v9"
@@ -13,9 +11,11 @@ import (
 )
 
 type PebbleDatabase struct {
-	db     *pebble.DB
-	hub    *kv.Hub
-	logger *zap.Logger
+	db      *pebble.DB
+	hub     *kv.Hub
+	expiry  *ttlBackend
+	secrets *SecretStore
+	logger  *zap.Logger
 }
 
 // NewPebble creates a new database driver instance with an underlying Pebble database
@@ -25,29 +25,78 @@ func NewPebble(directory string, logger *zap.Logger) (*PebbleDatabase, error) {
 		return nil, fmt.Errorf("could not open DB: %w", err)
 	}
 
-	// Create file for autodetect
-	err = os.WriteFile(filepath.Join(directory, "stul-driver"), []byte("pebble"), 0o644)
+	secrets, err := LoadSecretStore(directory, logger)
 	if err != nil {
-		return nil, fmt.Errorf("could not write driver file: %w", err)
+		_ = db.Close()
+		return nil, err
 	}
 
 	p := &PebbleDatabase{
-		db:     db,
-		hub:    nil,
-		logger: logger,
+		db:      db,
+		secrets: secrets,
+		logger:  logger,
+	}
+	p.hub, p.expiry, err = newHub(pebble_driver.NewPebbleBackend(p.db, true), p.secrets, p.logger)
+	if err != nil {
+		_ = db.Close()
+		return nil, fmt.Errorf("could not create hub: %w", err)
 	}
 
 	return p, nil
 }
 
 func (p *PebbleDatabase) Hub() *kv.Hub {
-	if p.hub == nil {
-		p.hub, _ = kv.NewHub(pebble_driver.NewPebbleBackend(p.db, true), kv.HubOptions{}, p.logger)
-	}
 	return p.hub
 }
 
+// Compact rewrites the whole keyspace, dropping deleted and overwritten entries
+func (p *PebbleDatabase) Compact() error {
+	iter := p.db.NewIter(nil)
+	var first, last []byte
+	if iter.First() {
+		first = append(first, iter.Key()...)
+	}
+	if iter.Last() {
+		last = append(last, iter.Key()...)
+	}
+	if err := iter.Close(); err != nil {
+		return err
+	}
+	if first == nil {
+		return nil
+	}
+	// End is exclusive, make sure the last key is included
+	return p.db.Compact(first, append(last, 0), true)
+}
+
+func (p *PebbleDatabase) Metrics() (StorageMetrics, error) {
+	metrics := p.db.Metrics()
+	out := StorageMetrics{
+		DiskSize:       int64(metrics.DiskSpaceUsage()),
+		CompactionDebt: metrics.Compact.EstimatedDebt,
+		WALFiles:       metrics.WAL.Files,
+		WALSize:        metrics.WAL.PhysicalSize,
+	}
+	for level, levelMetrics := range metrics.Levels {
+		out.Levels = append(out.Levels, LevelMetrics{
+			Level: level,
+			Files: levelMetrics.NumFiles,
+			Size:  levelMetrics.Size,
+		})
+	}
+	return out, nil
+}
+
+func (p *PebbleDatabase) Secrets() *SecretStore {
+	return p.secrets
+}
+
+func (p *PebbleDatabase) Watch(fn func(key string)) CancelFunc {
+	return p.expiry.watch(fn)
+}
+
 func (p *PebbleDatabase) Close() error {
+	p.expiry.Close()
 	err := p.db.Close()
 	if err != nil {
 		return fmt.Errorf("Could not close database: %w", err)
@@ -55,15 +104,8 @@ func (p *PebbleDatabase) Close() error {
 	return nil
 }
 
-func (p *PebbleDatabase) Import(entries map[string]string) error {
-	batch := p.db.NewBatch()
-	for key, value := range entries {
-		err := batch.Set([]byte(key), []byte(value), &pebble.WriteOptions{})
-		if err != nil {
-			return err
-		}
-	}
-	return batch.Commit(&pebble.WriteOptions{})
+func (p *PebbleDatabase) Import(file io.Reader) error {
+	return p.writeEntries(file, false, &pebble.WriteOptions{})
 }
 
 func (p *PebbleDatabase) Export(file io.Writer) error {
@@ -71,28 +113,67 @@ func (p *PebbleDatabase) Export(file io.Writer) error {
 }
 
 func (p *PebbleDatabase) Restore(file io.Reader) error {
-	in := make(map[string]string)
-	err := json.NewDecoder(file).Decode(&in)
-	if err != nil {
-		return fmt.Errorf("Could not decode backup: %w", err)
-	}
+	return p.writeEntries(file, true, &pebble.WriteOptions{Sync: true})
+}
 
+func (p *PebbleDatabase) Clear() error {
+	iter := p.db.NewIter(nil)
 	b := p.db.NewBatch()
-	for k, v := range in {
-		err = b.Set([]byte(k), []byte(v), nil)
-		if err != nil {
-			return fmt.Errorf("Could not set key %s: %w", k, err)
+	for iter.First(); iter.Valid(); iter.Next() {
+		if err := b.Delete(iter.Key(), nil); err != nil {
+			_ = iter.Close()
+			return err
+		}
+		// Keep batches small, like when writing entries
+		if b.Count() >= streamBatchSize {
+			if err := b.Commit(pebble.Sync); err != nil {
+				_ = iter.Close()
+				return err
+			}
+			b = p.db.NewBatch()
 		}
 	}
-
-	return b.Commit(&pebble.WriteOptions{Sync: true})
+	if err := iter.Close(); err != nil {
+		return err
+	}
+	return b.Commit(pebble.Sync)
 }
 
 func (p *PebbleDatabase) Backup(file io.Writer) error {
-	iter := p.db.NewSnapshot().NewIter(&pebble.IterOptions{})
-	out := make(map[string]string)
+	return writeEntries(file, p.Iterate)
+}
+
+// writeEntries streams a JSON object into the database, committing it in batches so memory usage stays flat.
+// If deleteEmpty is set, keys with an empty value are deleted instead.
+func (p *PebbleDatabase) writeEntries(file io.Reader, deleteEmpty bool, options *pebble.WriteOptions) error {
+	return readEntriesBatched(file, func(entries map[string]string) error {
+		b := p.db.NewBatch()
+		for k, v := range entries {
+			if deleteEmpty && v == "" {
+				if err := b.Delete([]byte(k), nil); err != nil {
+					return fmt.Errorf("Could not delete key %s: %w", k, err)
+				}
+				continue
+			}
+			err := b.Set([]byte(k), []byte(v), nil)
+			if err != nil {
+				return fmt.Errorf("Could not set key %s: %w", k, err)
+			}
+		}
+		return b.Commit(options)
+	})
+}
+
+func (p *PebbleDatabase) Iterate(fn func(key, value string) error) error {
+	snapshot := p.db.NewSnapshot()
+	defer snapshot.Close()
+
+	iter := snapshot.NewIter(&pebble.IterOptions{})
 	for iter.First(); iter.Valid(); iter.Next() {
-		out[string(iter.Key())] = string(iter.Value())
+		if err := fn(string(iter.Key()), string(iter.Value())); err != nil {
+			_ = iter.Close()
+			return err
+		}
 	}
-	return json.NewEncoder(file).Encode(out)
+	return iter.Close()
 }
@@ -0,0 +1,279 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	kv "github.com/strimertul/kilovolt/v9"
+	"go.uber.org/zap"
+	_ "modernc.org/sqlite"
+)
+
+// SQLiteFile is the name of the database file inside the database directory
+const SQLiteFile = "strimertul.db"
+
+type SQLiteDatabase struct {
+	db      *sql.DB
+	backend sqliteBackend
+	hub     *kv.Hub
+	expiry  *ttlBackend
+	secrets *SecretStore
+	logger  *zap.Logger
+}
+
+// NewSQLite creates a new database driver instance with an underlying SQLite database
+func NewSQLite(directory string, logger *zap.Logger) (*SQLiteDatabase, error) {
+	err := os.MkdirAll(directory, 0o755)
+	if err != nil {
+		return nil, fmt.Errorf("could not create database directory: %w", err)
+	}
+
+	dsn := fmt.Sprintf("file:%s?_pragma=journal_mode(WAL)&_pragma=busy_timeout(5000)", filepath.Join(directory, SQLiteFile))
+	db, err := sql.Open("sqlite", dsn)
+	if err != nil {
+		return nil, fmt.Errorf("could not open DB: %w", err)
+	}
+
+	_, err = db.Exec(`CREATE TABLE IF NOT EXISTS kv (key TEXT PRIMARY KEY NOT NULL, value TEXT NOT NULL) WITHOUT ROWID`)
+	if err != nil {
+		_ = db.Close()
+		return nil, fmt.Errorf("could not create schema: %w", err)
+	}
+
+	secrets, err := LoadSecretStore(directory, logger)
+	if err != nil {
+		_ = db.Close()
+		return nil, err
+	}
+
+	s := &SQLiteDatabase{
+		db:      db,
+		backend: sqliteBackend{db},
+		secrets: secrets,
+		logger:  logger,
+	}
+	s.hub, s.expiry, err = newHub(s.backend, s.secrets, s.logger)
+	if err != nil {
+		_ = db.Close()
+		return nil, fmt.Errorf("could not create hub: %w", err)
+	}
+
+	return s, nil
+}
+
+func (s *SQLiteDatabase) Hub() *kv.Hub {
+	return s.hub
+}
+
+// Compact rebuilds the database file, reclaiming the space of deleted rows
+func (s *SQLiteDatabase) Compact() error {
+	_, err := s.db.Exec(`VACUUM`)
+	return err
+}
+
+func (s *SQLiteDatabase) Metrics() (StorageMetrics, error) {
+	var pageSize, pageCount, freePages int64
+	for pragma, dst := range map[string]*int64{"page_size": &pageSize, "page_count": &pageCount, "freelist_count": &freePages} {
+		if err := s.db.QueryRow("PRAGMA " + pragma).Scan(dst); err != nil {
+			return StorageMetrics{}, err
+		}
+	}
+	return StorageMetrics{
+		DiskSize:    pageSize * pageCount,
+		Reclaimable: pageSize * freePages,
+	}, nil
+}
+
+func (s *SQLiteDatabase) Secrets() *SecretStore {
+	return s.secrets
+}
+
+func (s *SQLiteDatabase) Watch(fn func(key string)) CancelFunc {
+	return s.expiry.watch(fn)
+}
+
+func (s *SQLiteDatabase) Close() error {
+	s.expiry.Close()
+	err := s.db.Close()
+	if err != nil {
+		return fmt.Errorf("Could not close database: %w", err)
+	}
+	return nil
+}
+
+func (s *SQLiteDatabase) Import(file io.Reader) error {
+	return readEntriesBatched(file, s.backend.SetBulk)
+}
+
+func (s *SQLiteDatabase) Export(file io.Writer) error {
+	return s.Backup(file)
+}
+
+func (s *SQLiteDatabase) Restore(file io.Reader) error {
+	return restoreEntriesBatched(file, s.backend.SetBulk, s.backend.Delete)
+}
+
+func (s *SQLiteDatabase) Clear() error {
+	_, err := s.db.Exec(`DELETE FROM kv`)
+	return err
+}
+
+func (s *SQLiteDatabase) Backup(file io.Writer) error {
+	return writeEntries(file, s.Iterate)
+}
+
+func (s *SQLiteDatabase) Iterate(fn func(key, value string) error) error {
+	// Read everything inside a single transaction so we get a consistent snapshot
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.Query(`SELECT key, value FROM kv ORDER BY key`)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var key, value string
+		if err := rows.Scan(&key, &value); err != nil {
+			return err
+		}
+		if err := fn(key, value); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
+
+// sqliteBackend is a kilovolt driver storing everything in a single key/value table
+type sqliteBackend struct {
+	db *sql.DB
+}
+
+func (b sqliteBackend) Get(key string) (string, error) {
+	var value string
+	err := b.db.QueryRow(`SELECT value FROM kv WHERE key = ?`, key).Scan(&value)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return "", kv.ErrorKeyNotFound
+		}
+		return "", err
+	}
+	return value, nil
+}
+
+func (b sqliteBackend) GetBulk(keys []string) (map[string]string, error) {
+	out := make(map[string]string)
+	for _, key := range keys {
+		val, err := b.Get(key)
+		if err != nil {
+			if err == kv.ErrorKeyNotFound {
+				out[key] = ""
+				continue
+			}
+			return nil, err
+		}
+		out[key] = val
+	}
+	return out, nil
+}
+
+func (b sqliteBackend) GetPrefix(prefix string) (map[string]string, error) {
+	rows, err := b.queryPrefix(`SELECT key, value FROM kv`, prefix)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	out := make(map[string]string)
+	for rows.Next() {
+		var key, value string
+		if err := rows.Scan(&key, &value); err != nil {
+			return nil, err
+		}
+		out[key] = value
+	}
+	return out, rows.Err()
+}
+
+func (b sqliteBackend) Set(key, value string) error {
+	_, err := b.db.Exec(`INSERT INTO kv (key, value) VALUES (?, ?) ON CONFLICT(key) DO UPDATE SET value = excluded.value`, key, value)
+	return err
+}
+
+func (b sqliteBackend) SetBulk(kv map[string]string) error {
+	tx, err := b.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	stmt, err := tx.Prepare(`INSERT INTO kv (key, value) VALUES (?, ?) ON CONFLICT(key) DO UPDATE SET value = excluded.value`)
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+
+	for key, value := range kv {
+		if _, err := stmt.Exec(key, value); err != nil {
+			return fmt.Errorf("Could not set key %s: %w", key, err)
+		}
+	}
+	return tx.Commit()
+}
+
+func (b sqliteBackend) Delete(key string) error {
+	_, err := b.db.Exec(`DELETE FROM kv WHERE key = ?`, key)
+	return err
+}
+
+func (b sqliteBackend) List(prefix string) ([]string, error) {
+	rows, err := b.queryPrefix(`SELECT key FROM kv`, prefix)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	out := []string{}
+	for rows.Next() {
+		var key string
+		if err := rows.Scan(&key); err != nil {
+			return nil, err
+		}
+		out = append(out, key)
+	}
+	return out, rows.Err()
+}
+
+// queryPrefix runs a query restricted to keys starting with prefix, using a key range so the primary key index is used
+func (b sqliteBackend) queryPrefix(query string, prefix string) (*sql.Rows, error) {
+	upper := keyUpperBound(prefix)
+	switch {
+	case prefix == "":
+		return b.db.Query(query + ` ORDER BY key`)
+	case upper == "":
+		return b.db.Query(query+` WHERE key >= ? ORDER BY key`, prefix)
+	default:
+		return b.db.Query(query+` WHERE key >= ? AND key < ? ORDER BY key`, prefix, upper)
+	}
+}
+
+// keyUpperBound returns the smallest key that is greater than every key starting with prefix,
+// or an empty string if there is no such key
+func keyUpperBound(prefix string) string {
+	end := []byte(prefix)
+	for i := len(end) - 1; i >= 0; i-- {
+		end[i] = end[i] + 1
+		if end[i] != 0 {
+			return string(end[:i+1])
+		}
+	}
+	return ""
+}
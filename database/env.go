@@ -0,0 +1,82 @@
+package database
+
+import (
+	"os"
+	"sort"
+	"sync"
+)
+
+// EnvOverride is a field of a key that can be set with an environment variable instead, eg. to inject credentials
+// in a container without storing them. The value from the environment is only used by the module reading the key,
+// it's never written to the database.
+type EnvOverride struct {
+	Key      string `json:"key"`
+	Field    string `json:"field"` // JSON name of the field, must hold a string
+	Variable string `json:"variable"`
+}
+
+var envOverrides = struct {
+	sync.RWMutex
+	list []EnvOverride
+}{}
+
+// RegisterEnvOverride lets environment variables override fields of a key.
+// Modules should call this in init(), next to the definition of the key.
+func RegisterEnvOverride(overrides ...EnvOverride) {
+	envOverrides.Lock()
+	defer envOverrides.Unlock()
+	envOverrides.list = append(envOverrides.list, overrides...)
+}
+
+// EnvOverrides returns every registered override, sorted by variable
+func EnvOverrides() []EnvOverride {
+	envOverrides.RLock()
+	defer envOverrides.RUnlock()
+	list := make([]EnvOverride, len(envOverrides.list))
+	copy(list, envOverrides.list)
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].Variable < list[j].Variable
+	})
+	return list
+}
+
+// ActiveEnvOverrides returns the overrides whose environment variable is set (and not empty)
+func ActiveEnvOverrides() []EnvOverride {
+	active := []EnvOverride{}
+	for _, override := range EnvOverrides() {
+		if os.Getenv(override.Variable) != "" {
+			active = append(active, override)
+		}
+	}
+	return active
+}
+
+// ApplyEnvOverrides sets the fields of value (a pointer to what's stored in key) that are overridden by the environment
+func ApplyEnvOverrides(key string, value interface{}) error {
+	fields := make(map[string]string)
+	for _, override := range ActiveEnvOverrides() {
+		if override.Key == key {
+			fields[override.Field] = os.Getenv(override.Variable)
+		}
+	}
+	if len(fields) == 0 {
+		return nil
+	}
+
+	// Go through JSON so the fields can be found by the same names clients use
+	byt, err := json.Marshal(value)
+	if err != nil {
+		return err
+	}
+	var object map[string]interface{}
+	if err = json.Unmarshal(byt, &object); err != nil {
+		return err
+	}
+	for field, override := range fields {
+		object[field] = override
+	}
+	if byt, err = json.Marshal(object); err != nil {
+		return err
+	}
+	return json.Unmarshal(byt, value)
+}
@@ -0,0 +1,292 @@
+package database
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// RetentionPolicy decides how many entries an event log keeps, zero values mean no limit
+type RetentionPolicy struct {
+	MaxEntries int           // Oldest entries are removed once there are more than this
+	MaxAge     time.Duration // Entries are removed once they are older than this
+}
+
+// LogEntry is a single entry of an event log
+type LogEntry struct {
+	Key  string    `json:"key"`
+	Time time.Time `json:"time"`
+	Data string    `json:"data"`
+}
+
+// EventLog is an append-only list of entries, stored as one key per entry under a prefix so appending doesn't need
+// to rewrite the whole history. Keys are "<prefix><unix milliseconds>-<sequence>", zero-padded so they sort by time,
+// which lets kilovolt clients query a time range by listing keys (klist) and reading the ones they need (kget-bulk).
+type EventLog struct {
+	db        *LocalDBClient
+	prefix    string
+	retention RetentionPolicy
+
+	mu       sync.Mutex
+	lastTime int64
+	sequence int
+	entries  int
+
+	mirrorMu   sync.Mutex
+	mirrorKey  string
+	mirrorSize int
+	mirrored   []string
+}
+
+// NewEventLog opens the event log stored under prefix, applying the retention policy to existing entries
+func NewEventLog(db *LocalDBClient, prefix string, retention RetentionPolicy) (*EventLog, error) {
+	log := &EventLog{
+		db:        db,
+		prefix:    prefix,
+		retention: retention,
+	}
+
+	keys, err := log.keys()
+	if err != nil {
+		return nil, err
+	}
+	log.entries = len(keys)
+	if len(keys) > 0 {
+		// Keep appending after the last entry, in case it was written in this same millisecond
+		log.lastTime, log.sequence, _ = log.parseKey(keys[len(keys)-1])
+	}
+
+	if err = log.Trim(); err != nil {
+		return nil, err
+	}
+	return log, nil
+}
+
+// Prefix returns the prefix entries are stored under
+func (l *EventLog) Prefix() string {
+	return l.prefix
+}
+
+// SetRetention changes the retention policy, it's applied on the next append
+func (l *EventLog) SetRetention(retention RetentionPolicy) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.retention = retention
+}
+
+// Append adds an entry at the end of the log
+func (l *EventLog) Append(data string) (LogEntry, error) {
+	return l.AppendAt(time.Now(), data)
+}
+
+// AppendAt adds an entry that happened at a given time at the end of the log, useful for importing older entries.
+// Entries stay in order, an entry older than the last one gets the same time as the last one.
+func (l *EventLog) AppendAt(at time.Time, data string) (LogEntry, error) {
+	l.mu.Lock()
+	millis := at.UnixMilli()
+	if millis <= l.lastTime {
+		// Same millisecond (or older than the last entry), keep entries in order
+		millis = l.lastTime
+		l.sequence++
+	} else {
+		l.sequence = 0
+	}
+	l.lastTime = millis
+	entry := LogEntry{
+		Key:  l.formatKey(millis, l.sequence),
+		Time: time.UnixMilli(millis),
+		Data: data,
+	}
+	retention := l.retention
+	l.entries++
+	overLimit := retention.MaxEntries > 0 && l.entries > retention.MaxEntries+trimSlack(retention.MaxEntries)
+	l.mu.Unlock()
+
+	var err error
+	if retention.MaxAge > 0 {
+		// Old entries only get what's left of their lifetime
+		ttl := retention.MaxAge - time.Since(entry.Time)
+		if ttl <= 0 {
+			ttl = time.Millisecond
+		}
+		err = l.db.PutKeyWithTTL(entry.Key, data, ttl)
+	} else {
+		err = l.db.PutKey(entry.Key, data)
+	}
+	if err != nil {
+		return entry, err
+	}
+	if err = l.mirror(data); err != nil {
+		return entry, err
+	}
+
+	// Trimming needs to go through every key, only do it once in a while
+	if overLimit {
+		err = l.Trim()
+	}
+	return entry, err
+}
+
+// MirrorTo keeps key updated with a JSON array of the last size entries (which must be JSON), for clients that
+// read the history as a single key. The whole array is written on every append, so keep size small.
+func (l *EventLog) MirrorTo(key string, size int) error {
+	entries, err := l.Tail(size)
+	if err != nil {
+		return err
+	}
+
+	l.mirrorMu.Lock()
+	defer l.mirrorMu.Unlock()
+	l.mirrorKey = key
+	l.mirrorSize = size
+	l.mirrored = make([]string, 0, size)
+	for _, entry := range entries {
+		l.mirrored = append(l.mirrored, entry.Data)
+	}
+	return l.db.PutKey(key, "["+strings.Join(l.mirrored, ",")+"]")
+}
+
+// mirror adds a new entry to the key set with MirrorTo, if any
+func (l *EventLog) mirror(data string) error {
+	l.mirrorMu.Lock()
+	defer l.mirrorMu.Unlock()
+	if l.mirrorKey == "" {
+		return nil
+	}
+	l.mirrored = append(l.mirrored, data)
+	if len(l.mirrored) > l.mirrorSize {
+		l.mirrored = l.mirrored[len(l.mirrored)-l.mirrorSize:]
+	}
+	return l.db.PutKey(l.mirrorKey, "["+strings.Join(l.mirrored, ",")+"]")
+}
+
+// AppendJSON adds an entry at the end of the log, encoded as JSON
+func (l *EventLog) AppendJSON(data interface{}) error {
+	byt, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+	_, err = l.Append(string(byt))
+	return err
+}
+
+// Range returns the entries written between from and to (both inclusive), oldest first
+func (l *EventLog) Range(from time.Time, to time.Time) ([]LogEntry, error) {
+	keys, err := l.keys()
+	if err != nil {
+		return nil, err
+	}
+	start := sort.SearchStrings(keys, l.formatKey(from.UnixMilli(), 0))
+	end := sort.SearchStrings(keys, l.formatKey(to.UnixMilli()+1, 0))
+	return l.read(keys[start:end])
+}
+
+// Tail returns the last count entries, oldest first
+func (l *EventLog) Tail(count int) ([]LogEntry, error) {
+	keys, err := l.keys()
+	if err != nil {
+		return nil, err
+	}
+	if count < len(keys) {
+		keys = keys[len(keys)-count:]
+	}
+	return l.read(keys)
+}
+
+// Trim removes the entries exceeding the maximum size of the log, oldest first.
+// Entries older than the maximum age expire on their own.
+func (l *EventLog) Trim() error {
+	keys, err := l.keys()
+	if err != nil {
+		return err
+	}
+
+	l.mu.Lock()
+	maxEntries := l.retention.MaxEntries
+	l.mu.Unlock()
+
+	removed := 0
+	if maxEntries > 0 && len(keys) > maxEntries {
+		for _, key := range keys[:len(keys)-maxEntries] {
+			if err = l.db.RemoveKey(key); err != nil {
+				break
+			}
+			removed++
+		}
+	}
+
+	l.mu.Lock()
+	l.entries = len(keys) - removed
+	l.mu.Unlock()
+	return err
+}
+
+// keys returns the keys of every entry in the log, oldest first
+func (l *EventLog) keys() ([]string, error) {
+	keys, err := l.db.ListKeys(l.prefix)
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(keys)
+	return keys, nil
+}
+
+func (l *EventLog) read(keys []string) ([]LogEntry, error) {
+	if len(keys) == 0 {
+		return []LogEntry{}, nil
+	}
+	values, err := l.db.GetKeys(keys)
+	if err != nil {
+		return nil, err
+	}
+
+	entries := make([]LogEntry, 0, len(keys))
+	for _, key := range keys {
+		data := values[key]
+		// Removed (or expired) while we were reading
+		if data == "" {
+			continue
+		}
+		millis, _, err := l.parseKey(key)
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, LogEntry{
+			Key:  key,
+			Time: time.UnixMilli(millis),
+			Data: data,
+		})
+	}
+	return entries, nil
+}
+
+func (l *EventLog) formatKey(millis int64, sequence int) string {
+	return fmt.Sprintf("%s%016d-%04d", l.prefix, millis, sequence)
+}
+
+func (l *EventLog) parseKey(key string) (millis int64, sequence int, err error) {
+	timePart, sequencePart, ok := strings.Cut(strings.TrimPrefix(key, l.prefix), "-")
+	if !ok {
+		return 0, 0, fmt.Errorf("invalid event log key: %s", key)
+	}
+	if millis, err = strconv.ParseInt(timePart, 10, 64); err != nil {
+		return 0, 0, fmt.Errorf("invalid event log key: %s", key)
+	}
+	if sequence, err = strconv.Atoi(sequencePart); err != nil {
+		return 0, 0, fmt.Errorf("invalid event log key: %s", key)
+	}
+	return millis, sequence, nil
+}
+
+// trimSlack is how many entries over the limit a log can go before being trimmed, so that appending doesn't
+// have to list the whole log every time
+func trimSlack(maxEntries int) int {
+	slack := maxEntries / 10
+	if slack < 1 {
+		slack = 1
+	}
+	return slack
+}
@@ -0,0 +1,92 @@
+package database
+
+import (
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestEventLog(t *testing.T) {
+	driver := newTestMemoryDatabase(t)
+	go driver.Hub().Run()
+	db := newTestLocalClient(t, driver)
+
+	log, err := NewEventLog(db, "test/log/", RetentionPolicy{MaxEntries: 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = log.MirrorTo("test/history", 3); err != nil {
+		t.Fatal(err)
+	}
+
+	var entries []LogEntry
+	for i := 0; i < 20; i++ {
+		entry, err := log.Append(strconv.Itoa(i))
+		if err != nil {
+			t.Fatalf("could not append entry %d: %s", i, err)
+		}
+		entries = append(entries, entry)
+	}
+
+	// Entries written in the same millisecond must still be in order
+	tail, err := log.Tail(5)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(tail) != 5 {
+		t.Fatalf("tail has %d entries, expected 5", len(tail))
+	}
+	for index, entry := range tail {
+		if expected := strconv.Itoa(15 + index); entry.Data != expected {
+			t.Fatalf("tail entry %d is %s, expected %s", index, entry.Data, expected)
+		}
+	}
+
+	// Retention is applied once the log grows past its slack
+	if err = log.Trim(); err != nil {
+		t.Fatal(err)
+	}
+	all, err := log.Tail(100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(all) != 10 || all[0].Data != "10" {
+		t.Fatalf("log kept %d entries starting from %s, expected 10 starting from 10", len(all), all[0].Data)
+	}
+
+	inRange, err := log.Range(entries[12].Time, entries[19].Time)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Times are in milliseconds, so entries written in the same millisecond as the bounds are included too
+	found := make(map[string]bool)
+	for _, entry := range inRange {
+		if entry.Time.Before(entries[12].Time) || entry.Time.After(entries[19].Time) {
+			t.Fatalf("entry %s at %s is out of range", entry.Data, entry.Time)
+		}
+		found[entry.Data] = true
+	}
+	if !found["12"] || !found["19"] {
+		t.Fatalf("range is missing its bounds: %v", inRange)
+	}
+	if outside, err := log.Range(time.Now().Add(time.Hour), time.Now().Add(2*time.Hour)); err != nil || len(outside) != 0 {
+		t.Fatalf("range in the future returned %v (%v)", outside, err)
+	}
+
+	if history, err := db.GetKey("test/history"); err != nil || history != "[17,18,19]" {
+		t.Fatalf("mirrored history is %s (%v), expected [17,18,19]", history, err)
+	}
+
+	// Reopening the log keeps appending after the last entry
+	reopened, err := NewEventLog(db, "test/log/", RetentionPolicy{MaxEntries: 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+	entry, err := reopened.Append("20")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if entry.Key <= entries[19].Key {
+		t.Fatalf("new entry %s sorts before the last one %s", entry.Key, entries[19].Key)
+	}
+}
@@ -0,0 +1,87 @@
+package database
+
+import (
+	"io"
+	"strings"
+)
+
+// KeyFilter selects keys by prefix, an empty filter matches every key
+type KeyFilter struct {
+	Include []string // Only keep keys starting with one of these prefixes, empty means all keys
+	Exclude []string // Skip keys starting with one of these prefixes, takes priority over Include
+}
+
+// IsEmpty returns true if the filter matches every key
+func (f KeyFilter) IsEmpty() bool {
+	return len(f.Include) == 0 && len(f.Exclude) == 0
+}
+
+// Match returns true if the key is selected by the filter
+func (f KeyFilter) Match(key string) bool {
+	for _, prefix := range f.Exclude {
+		if strings.HasPrefix(key, prefix) {
+			return false
+		}
+	}
+	if len(f.Include) == 0 {
+		return true
+	}
+	for _, prefix := range f.Include {
+		if strings.HasPrefix(key, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// ExportOptions selects what ends up in an export
+type ExportOptions struct {
+	Filter         KeyFilter
+	IncludeSecrets bool // Export credentials (decrypted) instead of redacting them (see RegisterSecret)
+}
+
+// ExportWithOptions is like DatabaseDriver.Export but only writes keys matching the filter and redacts
+// secrets unless asked otherwise
+func ExportWithOptions(driver DatabaseDriver, file io.Writer, options ExportOptions) error {
+	return writeEntries(file, func(fn func(key, value string) error) error {
+		return driver.Iterate(func(key, value string) error {
+			if !options.Filter.Match(key) {
+				return nil
+			}
+			if options.IncludeSecrets {
+				value = driver.Secrets().Reveal(key, value)
+			} else {
+				var keep bool
+				if value, keep = RedactSecret(key, value); !keep {
+					return nil
+				}
+			}
+			return fn(key, value)
+		})
+	})
+}
+
+// ImportFiltered is like DatabaseDriver.Import but only imports keys matching the filter. Secrets missing from the
+// imported values (like in exports without secrets) keep their stored value.
+func ImportFiltered(driver DatabaseDriver, file io.Reader, filter KeyFilter) error {
+	// Re-encode the matching entries on the fly, so the driver still gets a single stream
+	reader, writer := io.Pipe()
+	go func() {
+		_ = writer.CloseWithError(writeEntries(writer, func(fn func(key, value string) error) error {
+			return readEntries(file, func(key, value string) error {
+				if !filter.Match(key) {
+					return nil
+				}
+				value, err := driver.Secrets().keepStoredSecrets(key, value)
+				if err != nil {
+					return err
+				}
+				return fn(key, value)
+			})
+		}))
+	}()
+	err := driver.Import(reader)
+	// Unblock the decoding side if the import stopped early
+	_ = reader.CloseWithError(err)
+	return err
+}
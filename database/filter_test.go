@@ -0,0 +1,105 @@
+package database
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestKeyFilter(t *testing.T) {
+	tests := []struct {
+		name   string
+		filter KeyFilter
+		key    string
+		match  bool
+	}{
+		{"empty filter", KeyFilter{}, "twitch/config", true},
+		{"included", KeyFilter{Include: []string{"twitch/"}}, "twitch/config", true},
+		{"not included", KeyFilter{Include: []string{"twitch/"}}, "loyalty/config", false},
+		{"one of many included", KeyFilter{Include: []string{"loyalty/", "twitch/"}}, "twitch/config", true},
+		{"excluded", KeyFilter{Exclude: []string{"twitch/"}}, "twitch/config", false},
+		{"exclude wins", KeyFilter{Include: []string{"twitch/"}, Exclude: []string{"twitch/bot-"}}, "twitch/bot-config", false},
+		{"included, not excluded", KeyFilter{Include: []string{"twitch/"}, Exclude: []string{"twitch/bot-"}}, "twitch/config", true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if match := test.filter.Match(test.key); match != test.match {
+				t.Fatalf("Match(%q) = %t, expected %t", test.key, match, test.match)
+			}
+		})
+	}
+}
+
+func TestImportFiltered(t *testing.T) {
+	driver := newTestMemoryDatabase(t)
+	data := `{"loyalty/config":"1","twitch/config":"2","twitch/bot-config":"3"}`
+	filter := KeyFilter{Include: []string{"twitch/"}, Exclude: []string{"twitch/bot-"}}
+	if err := ImportFiltered(driver, strings.NewReader(data), filter); err != nil {
+		t.Fatalf("could not import: %s", err)
+	}
+
+	state := readTestKeys(t, driver)
+	if len(state) != 1 || state["twitch/config"] != "2" {
+		t.Fatalf("unexpected keys after import: %v", state)
+	}
+}
+
+func TestExportFiltered(t *testing.T) {
+	driver := newTestMemoryDatabase(t)
+	setTestKeys(t, driver, map[string]string{"loyalty/config": "1", "twitch/config": "2"})
+
+	var buf bytes.Buffer
+	options := ExportOptions{Filter: KeyFilter{Include: []string{"loyalty/"}}}
+	if err := ExportWithOptions(driver, &buf, options); err != nil {
+		t.Fatalf("could not export: %s", err)
+	}
+	if exported := strings.TrimSpace(buf.String()); exported != `{"loyalty/config":"1"}` {
+		t.Fatalf("unexpected export: %s", exported)
+	}
+}
+
+func TestImportKeepsRedactedSecrets(t *testing.T) {
+	driver := newTestMemoryDatabase(t)
+	go driver.Hub().Run()
+	db := newTestLocalClient(t, driver)
+	if err := db.PutJSON(testSecretKey, map[string]string{"token": "hunter2", "name": "old"}); err != nil {
+		t.Fatal(err)
+	}
+
+	// Secrets are left out of exports, not emptied
+	var buf bytes.Buffer
+	if err := ExportWithOptions(driver, &buf, ExportOptions{Filter: KeyFilter{Include: []string{"test/"}}}); err != nil {
+		t.Fatalf("could not export: %s", err)
+	}
+	if exported := strings.TrimSpace(buf.String()); exported != `{"test/secret-config":"{\"name\":\"old\"}"}` {
+		t.Fatalf("unexpected export: %s", exported)
+	}
+
+	tests := []struct {
+		name     string
+		data     string
+		expected map[string]string
+	}{
+		{"redacted export", `{"test/secret-config":"{\"name\":\"new\"}"}`, map[string]string{"token": "hunter2", "name": "new"}},
+		{"emptied secret", `{"test/secret-config":"{\"name\":\"new\",\"token\":\"\"}"}`, map[string]string{"token": "hunter2", "name": "new"}},
+		{"export with secrets", `{"test/secret-config":"{\"name\":\"new\",\"token\":\"changed\"}"}`, map[string]string{"token": "changed", "name": "new"}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if err := db.PutJSON(testSecretKey, map[string]string{"token": "hunter2", "name": "old"}); err != nil {
+				t.Fatal(err)
+			}
+			if err := ImportFiltered(driver, strings.NewReader(test.data), KeyFilter{}); err != nil {
+				t.Fatalf("could not import: %s", err)
+			}
+			var value map[string]string
+			if err := db.GetSecretJSON(testSecretKey, &value); err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(value, test.expected) {
+				t.Fatalf("value after import is %v, expected %v", value, test.expected)
+			}
+		})
+	}
+}
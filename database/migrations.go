@@ -0,0 +1,178 @@
+package database
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// SchemaVersionKey holds the version of the last migration applied to the database
+const SchemaVersionKey = "stul-meta/schema-version"
+
+// Migration changes the shape of stored data from one schema version to the next
+type Migration struct {
+	Version     int    // Schema version the database will be at after this migration, must be unique
+	Description string // What the migration does, shown in logs
+	Migrate     func(db *LocalDBClient) error
+}
+
+var migrations = struct {
+	sync.Mutex
+	list []Migration
+}{}
+
+// RegisterMigration adds a migration to the list of migrations to run on startup.
+// Modules should call this in init(), next to the definition of the keys the migration changes.
+func RegisterMigration(migration Migration) {
+	migrations.Lock()
+	defer migrations.Unlock()
+
+	for _, existing := range migrations.list {
+		if existing.Version == migration.Version {
+			panic(fmt.Sprintf("duplicate migration for schema version %d: %q and %q", migration.Version, existing.Description, migration.Description))
+		}
+	}
+	migrations.list = append(migrations.list, migration)
+	sort.Slice(migrations.list, func(i, j int) bool {
+		return migrations.list[i].Version < migrations.list[j].Version
+	})
+}
+
+// LatestSchemaVersion returns the schema version the database will be at once every migration has run
+func LatestSchemaVersion() int {
+	migrations.Lock()
+	defer migrations.Unlock()
+
+	if len(migrations.list) == 0 {
+		return 0
+	}
+	return migrations.list[len(migrations.list)-1].Version
+}
+
+// GetSchemaVersion returns the schema version of the database, 0 if it was never set
+func GetSchemaVersion(db *LocalDBClient) (int, error) {
+	value, err := db.GetKey(SchemaVersionKey)
+	if err != nil {
+		return 0, err
+	}
+	if value == "" {
+		return 0, nil
+	}
+	return strconv.Atoi(value)
+}
+
+// RunMigrations brings the database to the latest schema version, it must run before any module reads its data.
+// If any migration needs to run, the database is backed up first and put back as it was if one of them fails.
+func RunMigrations(driver DatabaseDriver, db *LocalDBClient, backup BackupOptions, logger *zap.Logger) error {
+	latest := LatestSchemaVersion()
+
+	// New databases don't have anything to migrate
+	empty, err := isEmpty(driver)
+	if err != nil {
+		return err
+	}
+	if empty {
+		return db.PutKey(SchemaVersionKey, strconv.Itoa(latest))
+	}
+
+	current, err := GetSchemaVersion(db)
+	if err != nil {
+		return fmt.Errorf("could not read schema version: %w", err)
+	}
+	if current > latest {
+		logger.Warn("database was last used by a newer version of strimertul, some data might not be read correctly",
+			zap.Int("schema-version", current), zap.Int("supported-version", latest))
+		return nil
+	}
+
+	migrations.Lock()
+	var pending []Migration
+	for _, migration := range migrations.list {
+		if migration.Version > current {
+			pending = append(pending, migration)
+		}
+	}
+	migrations.Unlock()
+	if len(pending) == 0 {
+		return nil
+	}
+
+	backupFile, err := backupBefore(driver, backup, "migration")
+	if err != nil {
+		return fmt.Errorf("could not back up database before migrating, refusing to migrate: %w", err)
+	}
+	logger.Info("backed up database before migrating", zap.String("backup-file", backupFile))
+
+	for _, migration := range pending {
+		logger.Info("migrating database", zap.Int("schema-version", migration.Version), zap.String("migration", migration.Description))
+		if err = migration.Migrate(db); err != nil {
+			err = fmt.Errorf("migration to schema version %d (%s) failed: %w", migration.Version, migration.Description, err)
+			return rollbackMigrations(driver, backupFile, backup.Passphrase, err, logger)
+		}
+		if err = db.PutKey(SchemaVersionKey, strconv.Itoa(migration.Version)); err != nil {
+			return rollbackMigrations(driver, backupFile, backup.Passphrase, fmt.Errorf("could not save schema version: %w", err), logger)
+		}
+	}
+
+	return nil
+}
+
+// rollbackMigrations puts back the backup taken before migrating, so a failed migration never leaves the
+// database half-migrated. It returns the error that caused the rollback.
+func rollbackMigrations(driver DatabaseDriver, backupFile string, passphrase string, cause error, logger *zap.Logger) error {
+	info, err := ReadBackupInfo(backupFile)
+	if err == nil {
+		err = RestoreBackups(driver, []BackupInfo{info}, passphrase)
+	}
+	if err != nil {
+		return fmt.Errorf("%w (rolling back also failed, restore %s to go back: %s)", cause, backupFile, err.Error())
+	}
+	logger.Warn("rolled back database to before migrating", zap.String("backup-file", backupFile), zap.Error(cause))
+	return cause
+}
+
+// backupBefore takes a full backup named after the reason it was taken (eg. *-pre-migration.db)
+func backupBefore(driver DatabaseDriver, options BackupOptions, reason string) (string, error) {
+	directory := options.BackupDir
+	if directory == "" {
+		directory = os.TempDir()
+	}
+	if err := os.MkdirAll(directory, 0o755); err != nil {
+		return "", err
+	}
+
+	file, err := os.Create(filepath.Join(directory, time.Now().Format("20060102-150405")+"-pre-"+reason+".db"))
+	if err != nil {
+		return "", err
+	}
+	_, err = WriteBackupArchive(file, driver, options.DriverName, options.Passphrase)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		_ = os.Remove(file.Name())
+		return "", err
+	}
+	return file.Name(), nil
+}
+
+var errStopIteration = errors.New("stop iteration")
+
+func isEmpty(driver DatabaseDriver) (bool, error) {
+	empty := true
+	err := driver.Iterate(func(string, string) error {
+		empty = false
+		return errStopIteration
+	})
+	if err != nil && !errors.Is(err, errStopIteration) {
+		return false, err
+	}
+	return empty, nil
+}
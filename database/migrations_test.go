@@ -0,0 +1,144 @@
+package database
+
+import (
+	"errors"
+	"os"
+	"reflect"
+	"strconv"
+	"testing"
+
+	"go.uber.org/zap"
+)
+
+// setTestMigrations replaces the registered migrations for the duration of a test
+func setTestMigrations(t *testing.T, list []Migration) {
+	t.Helper()
+	migrations.Lock()
+	previous := migrations.list
+	migrations.list = list
+	migrations.Unlock()
+	t.Cleanup(func() {
+		migrations.Lock()
+		migrations.list = previous
+		migrations.Unlock()
+	})
+}
+
+func TestRunMigrations(t *testing.T) {
+	errBroken := errors.New("broken migration")
+
+	// Each migration appends its version to the "applied" key
+	applied := func(version int) Migration {
+		return Migration{
+			Version:     version,
+			Description: "test migration " + strconv.Itoa(version),
+			Migrate: func(db *LocalDBClient) error {
+				current, err := db.GetKey("applied")
+				if err != nil {
+					return err
+				}
+				return db.PutKey("applied", current+strconv.Itoa(version))
+			},
+		}
+	}
+	broken := Migration{
+		Version:     3,
+		Description: "broken migration",
+		Migrate: func(db *LocalDBClient) error {
+			if err := db.PutKey("data", "half-migrated"); err != nil {
+				return err
+			}
+			return errBroken
+		},
+	}
+
+	tests := []struct {
+		name       string
+		initial    map[string]string
+		migrations []Migration
+		expected   map[string]string
+		err        error
+		backedUp   bool
+	}{
+		{
+			name:       "new database",
+			migrations: []Migration{applied(1), applied(2)},
+			expected:   map[string]string{SchemaVersionKey: "2"},
+		},
+		{
+			name:       "version bump",
+			initial:    map[string]string{"data": "old"},
+			migrations: []Migration{applied(1), applied(2)},
+			expected:   map[string]string{SchemaVersionKey: "2", "data": "old", "applied": "12"},
+			backedUp:   true,
+		},
+		{
+			name:       "only pending migrations",
+			initial:    map[string]string{SchemaVersionKey: "1", "applied": "1"},
+			migrations: []Migration{applied(1), applied(2)},
+			expected:   map[string]string{SchemaVersionKey: "2", "applied": "12"},
+			backedUp:   true,
+		},
+		{
+			name:       "up to date",
+			initial:    map[string]string{SchemaVersionKey: "2", "applied": "12"},
+			migrations: []Migration{applied(1), applied(2)},
+			expected:   map[string]string{SchemaVersionKey: "2", "applied": "12"},
+		},
+		{
+			name:       "newer database",
+			initial:    map[string]string{SchemaVersionKey: "5", "data": "new"},
+			migrations: []Migration{applied(1), applied(2)},
+			expected:   map[string]string{SchemaVersionKey: "5", "data": "new"},
+		},
+		{
+			name:       "failure rollback",
+			initial:    map[string]string{SchemaVersionKey: "1", "applied": "1", "data": "old"},
+			migrations: []Migration{applied(1), applied(2), broken},
+			expected:   map[string]string{SchemaVersionKey: "1", "applied": "1", "data": "old"},
+			err:        errBroken,
+			backedUp:   true,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			setTestMigrations(t, test.migrations)
+			driver := newTestMemoryDatabase(t)
+			go driver.Hub().Run()
+			db := newTestLocalClient(t, driver)
+			if test.initial != nil {
+				setTestKeys(t, driver, test.initial)
+			}
+			options := BackupOptions{BackupDir: t.TempDir(), DriverName: "memory"}
+
+			err := RunMigrations(driver, db, options, zap.NewNop())
+			if !errors.Is(err, test.err) {
+				t.Fatalf("expected error %v, got %v", test.err, err)
+			}
+			if state := readTestKeys(t, driver); !reflect.DeepEqual(state, test.expected) {
+				t.Fatalf("database is %v, expected %v", state, test.expected)
+			}
+			backups, err := os.ReadDir(options.BackupDir)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if backedUp := len(backups) > 0; backedUp != test.backedUp {
+				t.Fatalf("backed up: %t, expected %t", backedUp, test.backedUp)
+			}
+
+			// Running again must not change anything nor take another backup
+			if test.err != nil {
+				return
+			}
+			if err = RunMigrations(driver, db, options, zap.NewNop()); err != nil {
+				t.Fatalf("second run failed: %s", err)
+			}
+			if state := readTestKeys(t, driver); !reflect.DeepEqual(state, test.expected) {
+				t.Fatalf("database after second run is %v, expected %v", state, test.expected)
+			}
+			if again, _ := os.ReadDir(options.BackupDir); len(again) != len(backups) {
+				t.Fatalf("second run took a backup")
+			}
+		})
+	}
+}
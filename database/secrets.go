@@ -0,0 +1,570 @@
+package database
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	jsoniter "github.com/json-iterator/go"
+	kv "github.com/strimertul/kilovolt/v9"
+	"go.uber.org/zap"
+)
+
+// secretFields maps keys holding sensitive data to the JSON fields that contain it,
+// a nil list means the whole value is sensitive
+var secretFields = struct {
+	sync.RWMutex
+	keys map[string][]string
+}{keys: make(map[string][]string)}
+
+// RegisterSecret marks a key (or some fields of a JSON object stored in it) as sensitive, so it can be left out
+// of exports. Modules should call this in init() for every key that contains credentials.
+func RegisterSecret(key string, fields ...string) {
+	secretFields.Lock()
+	defer secretFields.Unlock()
+
+	if len(fields) == 0 {
+		secretFields.keys[key] = nil
+		return
+	}
+	secretFields.keys[key] = append(secretFields.keys[key], fields...)
+}
+
+// SecretKeys returns every key registered as containing sensitive data
+func SecretKeys() []string {
+	secretFields.RLock()
+	defer secretFields.RUnlock()
+
+	keys := make([]string, 0, len(secretFields.keys))
+	for key := range secretFields.keys {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// SecretFields returns the fields of a key that hold secrets, nil if the whole key is secret.
+// ok is false if the key holds no secrets.
+func SecretFields(key string) (fields []string, ok bool) {
+	return secretFieldsOf(key)
+}
+
+// RedactSecret removes sensitive data from a value. Secret fields are removed, keys that are
+// secret as a whole are dropped (keep is false).
+func RedactSecret(key string, value string) (redacted string, keep bool) {
+	fields, ok := secretFieldsOf(key)
+	if !ok {
+		return value, true
+	}
+	if fields == nil {
+		return "", false
+	}
+
+	var object map[string]jsoniter.RawMessage
+	if err := json.UnmarshalFromString(value, &object); err != nil || object == nil {
+		// Not something we know how to redact, play it safe
+		return "", false
+	}
+	for _, field := range fields {
+		delete(object, field)
+	}
+	// Sort fields so values stay stable
+	redacted, err := jsoniter.ConfigCompatibleWithStandardLibrary.MarshalToString(object)
+	if err != nil {
+		return "", false
+	}
+	return redacted, true
+}
+
+const (
+	// SecretKeyFile is the name of the file holding the key used to encrypt secrets, inside the database directory
+	SecretKeyFile = "stul-secret.key"
+
+	// SecretMask replaces secrets when read through kilovolt, writing it back keeps the stored secret unchanged
+	SecretMask = "********"
+
+	// sealedPrefix marks encrypted values
+	sealedPrefix = "stul-secret:v1:"
+)
+
+// ErrSecretKeyMismatch is returned when copying the secret key to a directory that already has a different one
+var ErrSecretKeyMismatch = errors.New("destination already has a different secret key")
+
+// SecretStore encrypts the values registered with RegisterSecret before they reach the database.
+// Secrets are only available in clear to Go modules, through LocalDBClient.GetSecretJSON.
+type SecretStore struct {
+	aead    cipher.AEAD
+	backend kv.Driver // Unwrapped backend, set once a hub is created
+	logger  *zap.Logger
+}
+
+// LoadSecretStore loads the secret key from the database directory, creating a new one if there isn't any
+func LoadSecretStore(directory string, logger *zap.Logger) (*SecretStore, error) {
+	keyFile := filepath.Join(directory, SecretKeyFile)
+	encoded, err := os.ReadFile(keyFile)
+	if err == nil {
+		key, err := hex.DecodeString(strings.TrimSpace(string(encoded)))
+		if err != nil {
+			return nil, fmt.Errorf("invalid secret key file %s: %w", keyFile, err)
+		}
+		return newSecretStore(key, logger)
+	}
+	if !os.IsNotExist(err) {
+		return nil, fmt.Errorf("could not read secret key: %w", err)
+	}
+
+	key, err := generateSecretKey()
+	if err != nil {
+		return nil, err
+	}
+	if err = os.MkdirAll(directory, 0o755); err != nil {
+		return nil, err
+	}
+	if err = os.WriteFile(keyFile, []byte(hex.EncodeToString(key)), 0o600); err != nil {
+		return nil, fmt.Errorf("could not write secret key: %w", err)
+	}
+	logger.Info("generated new secret key", zap.String("file", keyFile))
+	return newSecretStore(key, logger)
+}
+
+// NewEphemeralSecretStore creates a secret store with a random key that is never saved
+func NewEphemeralSecretStore(logger *zap.Logger) (*SecretStore, error) {
+	key, err := generateSecretKey()
+	if err != nil {
+		return nil, err
+	}
+	return newSecretStore(key, logger)
+}
+
+// CopySecretKey copies the secret key file from one database directory to another, so encrypted
+// values can still be read after moving data between them
+func CopySecretKey(sourceDir string, targetDir string) error {
+	source, err := os.ReadFile(filepath.Join(sourceDir, SecretKeyFile))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	targetFile := filepath.Join(targetDir, SecretKeyFile)
+	target, err := os.ReadFile(targetFile)
+	if err == nil {
+		if !bytes.Equal(bytes.TrimSpace(source), bytes.TrimSpace(target)) {
+			return ErrSecretKeyMismatch
+		}
+		return nil
+	}
+	if !os.IsNotExist(err) {
+		return err
+	}
+	if err = os.MkdirAll(targetDir, 0o755); err != nil {
+		return err
+	}
+	return os.WriteFile(targetFile, source, 0o600)
+}
+
+func generateSecretKey() ([]byte, error) {
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		return nil, fmt.Errorf("could not generate secret key: %w", err)
+	}
+	return key, nil
+}
+
+func newSecretStore(key []byte, logger *zap.Logger) (*SecretStore, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("invalid secret key: %w", err)
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	return &SecretStore{aead: aead, logger: logger}, nil
+}
+
+func (s *SecretStore) encrypt(plain string) (string, error) {
+	nonce := make([]byte, s.aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+	sealed := s.aead.Seal(nonce, nonce, []byte(plain), nil)
+	return sealedPrefix + base64.StdEncoding.EncodeToString(sealed), nil
+}
+
+func (s *SecretStore) decrypt(value string) (string, error) {
+	sealed, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(value, sealedPrefix))
+	if err != nil {
+		return "", err
+	}
+	if len(sealed) < s.aead.NonceSize() {
+		return "", errors.New("sealed value is too short")
+	}
+	nonce, ciphertext := sealed[:s.aead.NonceSize()], sealed[s.aead.NonceSize():]
+	plain, err := s.aead.Open(nil, nonce, ciphertext, nil)
+	if err != nil {
+		return "", err
+	}
+	return string(plain), nil
+}
+
+// Seal encrypts the secrets in a value before it's written. Secrets set to SecretMask are replaced with the ones
+// in previous (the value currently stored), secrets that are already encrypted are kept as they are.
+func (s *SecretStore) Seal(key string, value string, previous string) (string, error) {
+	fields, ok := secretFieldsOf(key)
+	if !ok || value == "" {
+		return value, nil
+	}
+
+	sealOne := func(current string, old string) (string, error) {
+		switch {
+		case current == "", strings.HasPrefix(current, sealedPrefix):
+			return current, nil
+		case current == SecretMask:
+			// Keep whatever was there before, making sure it's encrypted
+			if old == "" || strings.HasPrefix(old, sealedPrefix) {
+				return old, nil
+			}
+			return s.encrypt(old)
+		default:
+			return s.encrypt(current)
+		}
+	}
+
+	if fields == nil {
+		return sealOne(value, previous)
+	}
+
+	oldFields := make(map[string]string)
+	_, _ = transformSecretFields(previous, fields, func(field string, old string) (string, error) {
+		oldFields[field] = old
+		return old, nil
+	})
+	sealed, err := transformSecretFields(value, fields, func(field string, current string) (string, error) {
+		return sealOne(current, oldFields[field])
+	})
+	if errors.Is(err, errNotAnObject) {
+		// We don't know where the secrets are, store it as is rather than lose data
+		return value, nil
+	}
+	return sealed, err
+}
+
+// Reveal decrypts the secrets in a stored value. Secrets that can't be decrypted (eg. the key file was lost)
+// are returned empty.
+func (s *SecretStore) Reveal(key string, value string) string {
+	fields, ok := secretFieldsOf(key)
+	if !ok || value == "" {
+		return value
+	}
+
+	revealOne := func(current string) string {
+		if !strings.HasPrefix(current, sealedPrefix) {
+			return current
+		}
+		plain, err := s.decrypt(current)
+		if err != nil {
+			s.logger.Warn("could not decrypt secret, it will need to be set again", zap.String("key", key), zap.Error(err))
+			return ""
+		}
+		return plain
+	}
+
+	if fields == nil {
+		return revealOne(value)
+	}
+	revealed, err := transformSecretFields(value, fields, func(_ string, current string) (string, error) {
+		return revealOne(current), nil
+	})
+	if err != nil {
+		return value
+	}
+	return revealed
+}
+
+// MaskSecret replaces the secrets in a value with SecretMask
+func MaskSecret(key string, value string) string {
+	fields, ok := secretFieldsOf(key)
+	if !ok || value == "" {
+		return value
+	}
+
+	if fields == nil {
+		return SecretMask
+	}
+	masked, err := transformSecretFields(value, fields, func(_ string, current string) (string, error) {
+		if current == "" {
+			return "", nil
+		}
+		return SecretMask, nil
+	})
+	if err != nil {
+		// Can't find the secrets, hide everything
+		return SecretMask
+	}
+	return masked
+}
+
+// sealExisting encrypts secrets that were stored in clear (eg. before secrets were encrypted or after an import)
+func (s *SecretStore) sealExisting(backend kv.Driver) {
+	for _, key := range SecretKeys() {
+		value, err := backend.Get(key)
+		if err != nil {
+			continue
+		}
+		sealed, err := s.Seal(key, value, value)
+		if err != nil {
+			s.logger.Error("could not encrypt secret", zap.String("key", key), zap.Error(err))
+			continue
+		}
+		if sealed == value {
+			continue
+		}
+		if err = backend.Set(key, sealed); err != nil {
+			s.logger.Error("could not encrypt secret", zap.String("key", key), zap.Error(err))
+		}
+	}
+}
+
+func secretFieldsOf(key string) ([]string, bool) {
+	secretFields.RLock()
+	defer secretFields.RUnlock()
+	fields, ok := secretFields.keys[key]
+	return fields, ok
+}
+
+var errNotAnObject = errors.New("value is not a JSON object")
+
+// transformSecretFields calls fn on every secret field with a string value in a JSON object and replaces it with
+// the result
+func transformSecretFields(value string, fields []string, fn func(field string, current string) (string, error)) (string, error) {
+	var object map[string]jsoniter.RawMessage
+	if err := json.UnmarshalFromString(value, &object); err != nil || object == nil {
+		return value, errNotAnObject
+	}
+	for _, field := range fields {
+		raw, ok := object[field]
+		if !ok {
+			continue
+		}
+		var current string
+		if err := json.Unmarshal(raw, &current); err != nil {
+			continue
+		}
+		updated, err := fn(field, current)
+		if err != nil {
+			return value, err
+		}
+		if updated == current {
+			continue
+		}
+		encoded, err := json.Marshal(updated)
+		if err != nil {
+			return value, err
+		}
+		object[field] = encoded
+	}
+	// Sort fields so values stay stable
+	return jsoniter.ConfigCompatibleWithStandardLibrary.MarshalToString(object)
+}
+
+// keepStoredSecrets fills the secret fields missing (or empty) in a value about to be imported with the stored ones,
+// so importing a redacted export doesn't wipe the credentials already set
+func (s *SecretStore) keepStoredSecrets(key string, value string) (string, error) {
+	fields, ok := secretFieldsOf(key)
+	if !ok || fields == nil || s.backend == nil {
+		return value, nil
+	}
+	stored, err := s.backend.Get(key)
+	if errors.Is(err, kv.ErrorKeyNotFound) {
+		return value, nil
+	}
+	if err != nil {
+		return value, err
+	}
+
+	var storedObject, object map[string]jsoniter.RawMessage
+	if err := json.UnmarshalFromString(stored, &storedObject); err != nil {
+		return value, nil
+	}
+	if err := json.UnmarshalFromString(value, &object); err != nil || object == nil {
+		return value, nil
+	}
+	changed := false
+	for _, field := range fields {
+		previous, ok := storedObject[field]
+		if !ok {
+			continue
+		}
+		var current string
+		if raw, ok := object[field]; ok && (json.Unmarshal(raw, &current) != nil || current != "") {
+			continue
+		}
+		object[field] = previous
+		changed = true
+	}
+	if !changed {
+		return value, nil
+	}
+	return jsoniter.ConfigCompatibleWithStandardLibrary.MarshalToString(object)
+}
+
+// secretBackend wraps a database backend, encrypting secrets on write and masking them on read
+type secretBackend struct {
+	kv.Driver
+	store *SecretStore
+}
+
+func (b secretBackend) Get(key string) (string, error) {
+	value, err := b.Driver.Get(key)
+	if err != nil {
+		return value, err
+	}
+	return MaskSecret(key, value), nil
+}
+
+func (b secretBackend) GetBulk(keys []string) (map[string]string, error) {
+	values, err := b.Driver.GetBulk(keys)
+	if err != nil {
+		return values, err
+	}
+	for key, value := range values {
+		values[key] = MaskSecret(key, value)
+	}
+	return values, nil
+}
+
+func (b secretBackend) GetPrefix(prefix string) (map[string]string, error) {
+	values, err := b.Driver.GetPrefix(prefix)
+	if err != nil {
+		return values, err
+	}
+	for key, value := range values {
+		values[key] = MaskSecret(key, value)
+	}
+	return values, nil
+}
+
+func (b secretBackend) Set(key string, value string) error {
+	sealed, err := b.seal(key, value)
+	if err != nil {
+		return err
+	}
+	return b.Driver.Set(key, sealed)
+}
+
+func (b secretBackend) SetBulk(kvs map[string]string) error {
+	sealed := make(map[string]string, len(kvs))
+	for key, value := range kvs {
+		var err error
+		if sealed[key], err = b.seal(key, value); err != nil {
+			return err
+		}
+		// The hub notifies subscribers of every key left in kvs, conditions are not changes
+		if strings.HasPrefix(key, ExpectPrefix) {
+			delete(kvs, key)
+		}
+	}
+	return b.Driver.SetBulk(sealed)
+}
+
+func (b secretBackend) seal(key string, value string) (string, error) {
+	if _, ok := secretFieldsOf(key); !ok {
+		return value, nil
+	}
+	previous, err := b.Driver.Get(key)
+	if err != nil && !errors.Is(err, kv.ErrorKeyNotFound) {
+		return "", err
+	}
+	return b.store.Seal(key, value, previous)
+}
+
+// newHub creates a kilovolt hub on top of a database backend, with secrets encrypted by store and
+// expired keys removed in the background until the returned backend is closed
+func newHub(backend kv.Driver, store *SecretStore, logger *zap.Logger) (*kv.Hub, *ttlBackend, error) {
+	store.backend = backend
+	store.sealExisting(backend)
+	expiring := newTTLBackend(backend, logger)
+	hub, err := kv.NewHub(secretBackend{Driver: expiring, store: store}, kv.HubOptions{}, logger)
+	if err != nil {
+		return nil, nil, err
+	}
+	go expiring.runSweeper(hub, store)
+	return hub, expiring, nil
+}
+
+// SealRequest encrypts the secrets in a raw kilovolt write request before it reaches the hub, which would
+// otherwise send them in clear to every subscriber of the key. Anything that isn't a valid write is returned as is,
+// for the hub to handle (or refuse) as usual.
+func (s *SecretStore) SealRequest(message []byte) ([]byte, error) {
+	var request kv.Request
+	if err := json.Unmarshal(message, &request); err != nil {
+		return message, nil
+	}
+	changed, err := s.sealRequestData(request.CmdName, request.Data)
+	if err != nil || !changed {
+		return message, err
+	}
+	return json.Marshal(request)
+}
+
+// sealRequestData encrypts the secrets written by a kset or kset-bulk request in place, changed is true if
+// any value was replaced
+func (s *SecretStore) sealRequestData(cmd string, data map[string]interface{}) (changed bool, err error) {
+	sealValue := func(key string, value interface{}) (interface{}, error) {
+		plain, ok := value.(string)
+		if !ok {
+			return value, nil
+		}
+		if _, ok = secretFieldsOf(key); !ok {
+			return value, nil
+		}
+		previous := ""
+		if s.backend != nil {
+			previous, err = s.backend.Get(key)
+			if err != nil && !errors.Is(err, kv.ErrorKeyNotFound) {
+				return nil, err
+			}
+		}
+		sealed, err := s.Seal(key, plain, previous)
+		if err != nil {
+			return nil, err
+		}
+		if sealed != plain {
+			changed = true
+		}
+		return sealed, nil
+	}
+
+	switch cmd {
+	case kv.CmdWriteKey:
+		key, ok := data["key"].(string)
+		if !ok {
+			return false, nil
+		}
+		value, ok := data["data"]
+		if !ok {
+			return false, nil
+		}
+		if data["data"], err = sealValue(key, value); err != nil {
+			return false, err
+		}
+	case kv.CmdWriteBulk:
+		for key, value := range data {
+			if data[key], err = sealValue(key, value); err != nil {
+				return false, err
+			}
+		}
+	}
+	return changed, nil
+}
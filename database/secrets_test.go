@@ -0,0 +1,108 @@
+package database
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	kv "github.com/strimertul/kilovolt/v9"
+	"go.uber.org/zap"
+)
+
+const testSecretKey = "test/secret-config"
+
+func init() {
+	RegisterSecret(testSecretKey, "token")
+}
+
+func newTestLocalClient(t *testing.T, driver DatabaseDriver) *LocalDBClient {
+	t.Helper()
+	client, err := NewLocalClient(driver.Hub(), driver.Secrets(), zap.NewNop())
+	if err != nil {
+		t.Fatalf("could not create client: %s", err)
+	}
+	t.Cleanup(func() {
+		_ = client.Close()
+	})
+	return client
+}
+
+func TestSecretsNeverNotifiedInClear(t *testing.T) {
+	driver := newTestMemoryDatabase(t)
+	go driver.Hub().Run()
+	writer := newTestLocalClient(t, driver)
+	subscriber := newTestLocalClient(t, driver)
+
+	notified := make(chan string, 1)
+	err, cancel := subscriber.SubscribeKey(testSecretKey, func(value string) {
+		notified <- value
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cancel()
+
+	if err = writer.PutKey(testSecretKey, `{"user":"me","token":"hunter2"}`); err != nil {
+		t.Fatalf("could not write secret: %s", err)
+	}
+	select {
+	case value := <-notified:
+		if strings.Contains(value, "hunter2") {
+			t.Fatalf("subscriber got the secret in clear: %s", value)
+		}
+		if !strings.Contains(value, `"user":"me"`) {
+			t.Fatalf("subscriber did not get the rest of the value: %s", value)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("subscriber was not notified")
+	}
+
+	var config struct {
+		User  string `json:"user"`
+		Token string `json:"token"`
+	}
+	if err = writer.GetSecretJSON(testSecretKey, &config); err != nil {
+		t.Fatal(err)
+	}
+	if config.Token != "hunter2" {
+		t.Fatalf("secret was not stored, got %q", config.Token)
+	}
+}
+
+func TestSealRequest(t *testing.T) {
+	driver := newTestMemoryDatabase(t)
+	store := driver.Secrets()
+
+	tests := []struct {
+		name    string
+		message string
+		sealed  bool
+	}{
+		{"write", `{"command":"kset","data":{"key":"` + testSecretKey + `","data":"{\"token\":\"hunter2\"}"}}`, true},
+		{"bulk write", `{"command":"kset-bulk","data":{"other":"1","` + testSecretKey + `":"{\"token\":\"hunter2\"}"}}`, true},
+		{"other key", `{"command":"kset","data":{"key":"other","data":"{\"token\":\"hunter2\"}"}}`, false},
+		{"read", `{"command":"kget","data":{"key":"` + testSecretKey + `"}}`, false},
+		{"invalid", `{"command":`, false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			sealed, err := store.SealRequest([]byte(test.message))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !test.sealed {
+				if string(sealed) != test.message {
+					t.Fatalf("request was changed: %s", sealed)
+				}
+				return
+			}
+			if strings.Contains(string(sealed), "hunter2") {
+				t.Fatalf("request still has the secret in clear: %s", sealed)
+			}
+			var request kv.Request
+			if err = json.Unmarshal(sealed, &request); err != nil {
+				t.Fatalf("sealed request is invalid: %s", err)
+			}
+		})
+	}
+}
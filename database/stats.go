@@ -0,0 +1,152 @@
+package database
+
+import (
+	"errors"
+	"io/fs"
+	"sort"
+	"strings"
+	"time"
+)
+
+// PrefixStats is how much space the keys under a prefix take
+type PrefixStats struct {
+	Prefix string `json:"prefix"`
+	Keys   int    `json:"keys"`
+	Size   int64  `json:"size"` // Sum of key and value sizes, in bytes
+}
+
+// KeyStats is how much space a single key takes
+type KeyStats struct {
+	Key  string `json:"key"`
+	Size int64  `json:"size"`
+}
+
+// LevelMetrics describes a level of an LSM tree (eg. Pebble)
+type LevelMetrics struct {
+	Level int   `json:"level"`
+	Files int64 `json:"files"`
+	Size  int64 `json:"size"`
+}
+
+// StorageMetrics are metrics of the storage engine, fields a driver doesn't have are left empty
+type StorageMetrics struct {
+	DiskSize       int64          `json:"disk_size"`       // Space used on disk, in bytes
+	Reclaimable    int64          `json:"reclaimable"`     // Space that compacting would free, in bytes
+	Levels         []LevelMetrics `json:"levels"`          // LSM tree levels
+	CompactionDebt uint64         `json:"compaction_debt"` // Bytes that need compacting to reach a stable state
+	WALFiles       int64          `json:"wal_files"`
+	WALSize        uint64         `json:"wal_size"` // Size of the write-ahead log on disk, in bytes
+}
+
+// MetricsReporter is implemented by drivers that can report metrics about their storage engine
+type MetricsReporter interface {
+	Metrics() (StorageMetrics, error)
+}
+
+// DatabaseStats is an overview of what is stored in a database
+type DatabaseStats struct {
+	Keys        int             `json:"keys"`
+	Size        int64           `json:"size"`
+	Prefixes    []PrefixStats   `json:"prefixes"`     // Biggest first
+	LargestKeys []KeyStats      `json:"largest_keys"` // Biggest first
+	Storage     *StorageMetrics `json:"storage"`      // nil if the driver doesn't report metrics
+	LastBackup  *time.Time      `json:"last_backup"`  // nil if there are no backups
+}
+
+// StatsOptions controls how statistics are collected
+type StatsOptions struct {
+	Depth       int    // How many segments of a key make up its prefix (eg. 2 groups "twitch/config" and "twitch/bot-config" separately)
+	LargestKeys int    // How many of the largest keys to report
+	BackupDir   string // Where to look for the last backup, empty to skip
+}
+
+// CollectStats goes through the whole database, counting keys and sizes per prefix
+func CollectStats(driver DatabaseDriver, options StatsOptions) (DatabaseStats, error) {
+	stats := DatabaseStats{
+		Prefixes:    []PrefixStats{},
+		LargestKeys: []KeyStats{},
+	}
+	prefixes := make(map[string]*PrefixStats)
+	err := driver.Iterate(func(key, value string) error {
+		size := int64(len(key) + len(value))
+		stats.Keys++
+		stats.Size += size
+
+		prefix := keyPrefix(key, options.Depth)
+		entry, ok := prefixes[prefix]
+		if !ok {
+			entry = &PrefixStats{Prefix: prefix}
+			prefixes[prefix] = entry
+		}
+		entry.Keys++
+		entry.Size += size
+
+		if options.LargestKeys > 0 {
+			stats.LargestKeys = addLargest(stats.LargestKeys, KeyStats{Key: key, Size: size}, options.LargestKeys)
+		}
+		return nil
+	})
+	if err != nil {
+		return stats, err
+	}
+
+	for _, entry := range prefixes {
+		stats.Prefixes = append(stats.Prefixes, *entry)
+	}
+	sort.Slice(stats.Prefixes, func(i, j int) bool {
+		if stats.Prefixes[i].Size != stats.Prefixes[j].Size {
+			return stats.Prefixes[i].Size > stats.Prefixes[j].Size
+		}
+		return stats.Prefixes[i].Prefix < stats.Prefixes[j].Prefix
+	})
+
+	if reporter, ok := driver.(MetricsReporter); ok {
+		metrics, err := reporter.Metrics()
+		if err != nil {
+			return stats, err
+		}
+		stats.Storage = &metrics
+	}
+
+	if options.BackupDir != "" {
+		backups, err := ListBackups(options.BackupDir)
+		if err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return stats, err
+		}
+		if len(backups) > 0 {
+			last := backups[len(backups)-1].Header.Time
+			stats.LastBackup = &last
+		}
+	}
+
+	return stats, nil
+}
+
+// keyPrefix returns the first depth segments of a key, with a trailing slash if the key has more segments
+func keyPrefix(key string, depth int) string {
+	if depth < 1 {
+		depth = 1
+	}
+	parts := strings.SplitN(key, "/", depth+1)
+	if len(parts) <= depth {
+		return key
+	}
+	return strings.Join(parts[:depth], "/") + "/"
+}
+
+// addLargest inserts a key in a list sorted by size (biggest first), keeping at most count keys
+func addLargest(list []KeyStats, key KeyStats, count int) []KeyStats {
+	if len(list) >= count && key.Size <= list[len(list)-1].Size {
+		return list
+	}
+	index := sort.Search(len(list), func(i int) bool {
+		return list[i].Size < key.Size
+	})
+	list = append(list, KeyStats{})
+	copy(list[index+1:], list[index:])
+	list[index] = key
+	if len(list) > count {
+		list = list[:count]
+	}
+	return list
+}
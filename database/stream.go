@@ -0,0 +1,106 @@
+package database
+
+import (
+	"fmt"
+	"io"
+
+	jsoniter "github.com/json-iterator/go"
+)
+
+const (
+	// streamBufferSize is the size of the buffers used when encoding/decoding entries
+	streamBufferSize = 64 * 1024
+
+	// streamBatchSize is how many entries are buffered before being written to the database
+	streamBatchSize = 1000
+)
+
+// writeEntries encodes every entry produced by iterate as a single JSON object, without holding the
+// whole object in memory
+func writeEntries(w io.Writer, iterate func(fn func(key, value string) error) error) error {
+	stream := jsoniter.NewStream(json, w, streamBufferSize)
+	stream.WriteObjectStart()
+	first := true
+	err := iterate(func(key, value string) error {
+		if !first {
+			stream.WriteMore()
+		}
+		first = false
+		stream.WriteObjectField(key)
+		stream.WriteString(value)
+		if stream.Buffered() >= streamBufferSize {
+			return stream.Flush()
+		}
+		return stream.Error
+	})
+	if err != nil {
+		return err
+	}
+	stream.WriteObjectEnd()
+	stream.WriteRaw("\n")
+	return stream.Flush()
+}
+
+// readEntries decodes a JSON object of string values one entry at a time, calling fn for each of them
+func readEntries(r io.Reader, fn func(key, value string) error) error {
+	iter := jsoniter.Parse(json, r, streamBufferSize)
+	var fnErr error
+	iter.ReadObjectCB(func(iter *jsoniter.Iterator, key string) bool {
+		value := iter.ReadString()
+		if iter.Error != nil {
+			return false
+		}
+		fnErr = fn(key, value)
+		return fnErr == nil
+	})
+	if fnErr != nil {
+		return fnErr
+	}
+	if iter.Error != nil {
+		return fmt.Errorf("Could not decode backup: %w", iter.Error)
+	}
+	return nil
+}
+
+// restoreEntriesBatched reads entries in batches like readEntriesBatched, calling remove for every entry
+// with an empty value instead of writing it
+func restoreEntriesBatched(r io.Reader, set func(batch map[string]string) error, remove func(key string) error) error {
+	return readEntriesBatched(r, func(batch map[string]string) error {
+		for key, value := range batch {
+			if value != "" {
+				continue
+			}
+			if err := remove(key); err != nil {
+				return err
+			}
+			delete(batch, key)
+		}
+		if len(batch) == 0 {
+			return nil
+		}
+		return set(batch)
+	})
+}
+
+// readEntriesBatched is like readEntries but hands entries over in batches of up to streamBatchSize
+func readEntriesBatched(r io.Reader, fn func(batch map[string]string) error) error {
+	batch := make(map[string]string)
+	err := readEntries(r, func(key, value string) error {
+		batch[key] = value
+		if len(batch) < streamBatchSize {
+			return nil
+		}
+		if err := fn(batch); err != nil {
+			return err
+		}
+		batch = make(map[string]string)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	if len(batch) > 0 {
+		return fn(batch)
+	}
+	return nil
+}
@@ -0,0 +1,111 @@
+package database
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	kv "github.com/strimertul/kilovolt/v9"
+)
+
+// maxTransactionAttempts is how many times a transaction is retried when its conditions fail
+const maxTransactionAttempts = 5
+
+// ErrConditionFailed is returned when a key checked by a transaction was changed by someone else
+var ErrConditionFailed = errors.New("transaction condition failed")
+
+// Transaction collects writes that are applied all at once, optionally only if some keys still have the
+// values they were expected to have (compare-and-swap)
+type Transaction struct {
+	db         *LocalDBClient
+	conditions map[string]string
+	writes     map[string]string
+}
+
+// Get reads a key and adds a condition on its current value, so the transaction fails if it changes
+// before being committed. Missing keys are returned (and expected) as empty.
+func (tx *Transaction) Get(key string) (string, error) {
+	value, err := tx.db.GetKey(key)
+	if err != nil {
+		return "", err
+	}
+	tx.Expect(key, value)
+	return value, nil
+}
+
+// GetJSON is like Get but decodes the value as JSON, returning ErrEmptyKey if the key is empty
+func (tx *Transaction) GetJSON(key string, dst interface{}) error {
+	value, err := tx.Get(key)
+	if err != nil {
+		return err
+	}
+	if value == "" {
+		return ErrEmptyKey
+	}
+	return json.UnmarshalFromString(value, dst)
+}
+
+// Expect adds a condition for the transaction to be committed: the key must have this value ("" for missing keys)
+func (tx *Transaction) Expect(key string, value string) {
+	tx.conditions[key] = value
+}
+
+// Put adds a write to the transaction
+func (tx *Transaction) Put(key string, data string) {
+	tx.writes[key] = data
+}
+
+// PutJSON adds a write to the transaction, encoding data as JSON
+func (tx *Transaction) PutJSON(key string, data interface{}) error {
+	byt, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+	tx.Put(key, string(byt))
+	return nil
+}
+
+// Transaction calls fn to build a transaction and then commits it, all writes are applied together or not at all.
+// If any key read with Get (or checked with Expect) was changed in the meantime, fn is called again with a new
+// transaction, so it must not have side effects outside of it. Conditions are checked by the database while applying
+// the writes, so they hold against every writer, not just other transactions.
+func (mod *LocalDBClient) Transaction(fn func(tx *Transaction) error) error {
+	var err error
+	for attempt := 0; attempt < maxTransactionAttempts; attempt++ {
+		tx := &Transaction{
+			db:         mod,
+			conditions: make(map[string]string),
+			writes:     make(map[string]string),
+		}
+		if err = fn(tx); err != nil {
+			return err
+		}
+		err = mod.commit(tx)
+		if !errors.Is(err, ErrConditionFailed) {
+			return err
+		}
+	}
+	return err
+}
+
+// commit sends the writes and conditions of a transaction as a single conditional kset-bulk request (see ExpectPrefix)
+func (mod *LocalDBClient) commit(tx *Transaction) error {
+	if len(tx.conditions) == 0 && len(tx.writes) == 0 {
+		return nil
+	}
+	request := make(map[string]interface{}, len(tx.conditions)+len(tx.writes))
+	for key, value := range tx.conditions {
+		request[ExpectPrefix+key] = value
+	}
+	for key, value := range tx.writes {
+		request[key] = value
+	}
+	_, err := mod.makeRequest(kv.CmdWriteBulk, request)
+
+	// The hub only sends back the error message
+	var kvErr *KvError
+	if errors.As(err, &kvErr) && strings.HasPrefix(kvErr.ErrorData.Details, ErrConditionFailed.Error()) {
+		return fmt.Errorf("%w%s", ErrConditionFailed, kvErr.ErrorData.Details[len(ErrConditionFailed.Error()):])
+	}
+	return err
+}
@@ -0,0 +1,149 @@
+package database
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestTransaction(t *testing.T) {
+	driver := newTestMemoryDatabase(t)
+	go driver.Hub().Run()
+	db := newTestLocalClient(t, driver)
+	other := newTestLocalClient(t, driver)
+	setTestKeys(t, driver, map[string]string{"points": "10"})
+
+	// Another client changes the key between the read and the commit, so the first attempt must be retried
+	attempts := 0
+	err := db.Transaction(func(tx *Transaction) error {
+		attempts++
+		points, err := tx.Get("points")
+		if err != nil {
+			return err
+		}
+		if attempts == 1 {
+			if err = other.PutKey("points", "20"); err != nil {
+				return err
+			}
+		}
+		value, _ := strconv.Atoi(points)
+		tx.Put("points", strconv.Itoa(value-5))
+		tx.Put("redeem", "points: "+points)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("transaction failed: %s", err)
+	}
+	if attempts != 2 {
+		t.Fatalf("transaction ran %d times, expected 2", attempts)
+	}
+	state := readTestKeys(t, driver)
+	if state["points"] != "15" || state["redeem"] != "points: 20" {
+		t.Fatalf("unexpected state after transaction: %v", state)
+	}
+
+	// Errors from fn abort without writing anything
+	errAbort := errors.New("not enough points")
+	err = db.Transaction(func(tx *Transaction) error {
+		tx.Put("points", "0")
+		return errAbort
+	})
+	if !errors.Is(err, errAbort) {
+		t.Fatalf("expected %v, got %v", errAbort, err)
+	}
+	if state = readTestKeys(t, driver); state["points"] != "15" {
+		t.Fatalf("aborted transaction was written: %v", state)
+	}
+}
+
+func TestCompareAndSwap(t *testing.T) {
+	driver := newTestMemoryDatabase(t)
+	go driver.Hub().Run()
+	db := newTestLocalClient(t, driver)
+	setTestKeys(t, driver, map[string]string{"state": "idle"})
+
+	// Conditions must never reach subscribers
+	notified := make(chan string, 10)
+	err, cancel := db.SubscribePrefix(func(key string, _ string) {
+		notified <- key
+	}, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cancel()
+
+	tests := []struct {
+		name     string
+		key      string
+		old      string
+		new      string
+		swapped  bool
+		expected string
+	}{
+		{"matching value", "state", "idle", "playing", true, "playing"},
+		{"changed value", "state", "idle", "stopped", false, "playing"},
+		{"missing key", "new-state", "", "idle", true, "idle"},
+		{"key exists", "new-state", "", "playing", false, "idle"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			swapped, err := db.CompareAndSwap(test.key, test.old, test.new)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if swapped != test.swapped {
+				t.Fatalf("swapped: %t, expected %t", swapped, test.swapped)
+			}
+			if value := readTestKeys(t, driver)[test.key]; value != test.expected {
+				t.Fatalf("%s is %q, expected %q", test.key, value, test.expected)
+			}
+		})
+	}
+
+	timeout := time.After(100 * time.Millisecond)
+	for {
+		select {
+		case key := <-notified:
+			if strings.HasPrefix(key, ExpectPrefix) {
+				t.Fatalf("subscribers were notified of condition %s", key)
+			}
+		case <-timeout:
+			return
+		}
+	}
+}
+
+func TestIncrementAcrossClients(t *testing.T) {
+	driver := newTestMemoryDatabase(t)
+	go driver.Hub().Run()
+
+	// Every successful increment must be counted, no matter which client did it
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	succeeded := 0
+	for i := 0; i < 4; i++ {
+		db := newTestLocalClient(t, driver)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 25; j++ {
+				if _, err := db.Increment("counter", 1); err == nil {
+					mu.Lock()
+					succeeded++
+					mu.Unlock()
+				} else if !errors.Is(err, ErrConditionFailed) {
+					t.Error(err)
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	if value := readTestKeys(t, driver)["counter"]; value != strconv.Itoa(succeeded) {
+		t.Fatalf("counter is %s after %d increments", value, succeeded)
+	}
+}
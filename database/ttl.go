@@ -0,0 +1,385 @@
+package database
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	kv "github.com/strimertul/kilovolt/v9"
+	"go.uber.org/zap"
+)
+
+// ExpiryPrefix is where the expiration time (unix milliseconds) of keys with a TTL is stored,
+// eg. the expiration of "twitch/ev/chat-message" is saved in "stul-meta/expires/twitch/ev/chat-message"
+const ExpiryPrefix = "stul-meta/expires/"
+
+const (
+	// EventTTL is how long event keys (eg. twitch/ev/chat-message) are kept, they only matter when they are written
+	EventTTL = time.Hour
+	// RPCTTL is how long RPC keys (eg. twitch/@send-chat-message) are kept, they are handled as soon as they are written
+	RPCTTL = time.Minute
+)
+
+// sweepInterval is how often expired keys are removed from the database
+const sweepInterval = time.Minute
+
+var (
+	ttlMu    sync.RWMutex
+	ttlRules = make(map[string]time.Duration)
+)
+
+// RegisterTTL makes every write to key expire after ttl, no matter who writes it (useful for keys written by
+// kilovolt clients, like RPC keys). If key ends with "/" the TTL applies to every key starting with it.
+// Expired keys are removed with an empty value, so subscribers handling the key must ignore it.
+func RegisterTTL(key string, ttl time.Duration) {
+	ttlMu.Lock()
+	defer ttlMu.Unlock()
+	ttlRules[key] = ttl
+}
+
+// registeredTTL returns the TTL registered for a key, if any
+func registeredTTL(key string) (time.Duration, bool) {
+	ttlMu.RLock()
+	defer ttlMu.RUnlock()
+	if ttl, ok := ttlRules[key]; ok {
+		return ttl, true
+	}
+	for rule, ttl := range ttlRules {
+		if strings.HasSuffix(rule, "/") && strings.HasPrefix(key, rule) {
+			return ttl, true
+		}
+	}
+	return 0, false
+}
+
+func formatExpiry(deadline time.Time) string {
+	return strconv.FormatInt(deadline.UnixMilli(), 10)
+}
+
+func parseExpiry(value string) (time.Time, error) {
+	millis, err := strconv.ParseInt(value, 10, 64)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return time.UnixMilli(millis), nil
+}
+
+// ttlBackend wraps a database backend, keeping track of key expiration and hiding expired keys until they are swept.
+// Writing a key without an expiration makes it permanent again (unless it has a registered TTL).
+type ttlBackend struct {
+	kv.Driver
+	mu        sync.Mutex
+	deadlines map[string]time.Time
+	logger    *zap.Logger
+	stop      chan struct{}
+	done      chan struct{}
+	stopOnce  sync.Once
+	watchers  map[int]func(key string)
+	watcherID int
+}
+
+func newTTLBackend(backend kv.Driver, logger *zap.Logger) *ttlBackend {
+	b := &ttlBackend{
+		Driver:    backend,
+		deadlines: make(map[string]time.Time),
+		logger:    logger,
+		stop:      make(chan struct{}),
+		done:      make(chan struct{}),
+		watchers:  make(map[int]func(key string)),
+	}
+
+	expirations, err := backend.GetPrefix(ExpiryPrefix)
+	if err != nil {
+		logger.Error("could not load key expirations, keys with a TTL won't expire", zap.Error(err))
+	}
+	for metaKey, value := range expirations {
+		deadline, err := parseExpiry(value)
+		if err != nil {
+			logger.Warn("invalid key expiration, ignoring", zap.String("key", metaKey), zap.Error(err))
+			continue
+		}
+		b.deadlines[metaKey[len(ExpiryPrefix):]] = deadline
+	}
+
+	return b
+}
+
+func (b *ttlBackend) expired(key string, now time.Time) bool {
+	deadline, ok := b.deadlines[key]
+	return ok && !now.Before(deadline)
+}
+
+func (b *ttlBackend) Get(key string) (string, error) {
+	b.mu.Lock()
+	expired := b.expired(key, time.Now())
+	b.mu.Unlock()
+	if expired {
+		return "", kv.ErrorKeyNotFound
+	}
+	return b.Driver.Get(key)
+}
+
+func (b *ttlBackend) GetBulk(keys []string) (map[string]string, error) {
+	values, err := b.Driver.GetBulk(keys)
+	if err != nil {
+		return values, err
+	}
+	b.hideExpired(values, true)
+	return values, nil
+}
+
+func (b *ttlBackend) GetPrefix(prefix string) (map[string]string, error) {
+	values, err := b.Driver.GetPrefix(prefix)
+	if err != nil {
+		return values, err
+	}
+	b.hideExpired(values, false)
+	return values, nil
+}
+
+func (b *ttlBackend) List(prefix string) ([]string, error) {
+	keys, err := b.Driver.List(prefix)
+	if err != nil {
+		return keys, err
+	}
+	now := time.Now()
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	live := keys[:0]
+	for _, key := range keys {
+		if !b.expired(key, now) {
+			live = append(live, key)
+		}
+	}
+	return live, nil
+}
+
+// hideExpired removes expired keys from a lookup, or blanks them if blank is set
+// (that's how GetBulk returns missing keys)
+func (b *ttlBackend) hideExpired(values map[string]string, blank bool) {
+	now := time.Now()
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for key := range values {
+		if !b.expired(key, now) {
+			continue
+		}
+		if blank {
+			values[key] = ""
+		} else {
+			delete(values, key)
+		}
+	}
+}
+
+func (b *ttlBackend) Set(key string, value string) error {
+	return b.SetBulk(map[string]string{key: value})
+}
+
+func (b *ttlBackend) SetBulk(kvs map[string]string) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	now := time.Now()
+	kvs, err := b.checkConditions(kvs, now)
+	if err != nil {
+		return err
+	}
+	writes := make(map[string]string, len(kvs))
+	deadlines := make(map[string]time.Time)
+	var cleared []string
+	for key, value := range kvs {
+		writes[key] = value
+		if strings.HasPrefix(key, ExpiryPrefix) {
+			deadline, err := parseExpiry(value)
+			if err != nil {
+				return err
+			}
+			deadlines[key[len(ExpiryPrefix):]] = deadline
+		}
+	}
+	var removed []string
+	for key := range kvs {
+		if strings.HasPrefix(key, ExpiryPrefix) {
+			continue
+		}
+		// Expiration written together with the key, the key is removed right away if it's already passed
+		if deadline, ok := deadlines[key]; ok {
+			if !now.Before(deadline) {
+				delete(writes, key)
+				delete(writes, ExpiryPrefix+key)
+				delete(deadlines, key)
+				removed = append(removed, key)
+			}
+			continue
+		}
+		if ttl, ok := registeredTTL(key); ok {
+			deadline := now.Add(ttl)
+			deadlines[key] = deadline
+			writes[ExpiryPrefix+key] = formatExpiry(deadline)
+			continue
+		}
+		if _, ok := b.deadlines[key]; ok {
+			cleared = append(cleared, key)
+		}
+	}
+
+	if err = b.Driver.SetBulk(writes); err != nil {
+		return err
+	}
+	for key := range writes {
+		b.changed(key)
+	}
+	for key, deadline := range deadlines {
+		b.deadlines[key] = deadline
+	}
+	for _, key := range cleared {
+		if err := b.Driver.Delete(ExpiryPrefix + key); err != nil {
+			return err
+		}
+		b.changed(ExpiryPrefix + key)
+		delete(b.deadlines, key)
+	}
+	for _, key := range removed {
+		if err := b.remove(key); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (b *ttlBackend) Delete(key string) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.remove(key)
+}
+
+// remove deletes a key along with its expiration, must be called with the lock held
+func (b *ttlBackend) remove(key string) error {
+	if err := b.Driver.Delete(key); err != nil {
+		return err
+	}
+	b.changed(key)
+	if strings.HasPrefix(key, ExpiryPrefix) {
+		delete(b.deadlines, key[len(ExpiryPrefix):])
+		return nil
+	}
+	if _, ok := b.deadlines[key]; !ok {
+		return nil
+	}
+	if err := b.Driver.Delete(ExpiryPrefix + key); err != nil {
+		return err
+	}
+	b.changed(ExpiryPrefix + key)
+	delete(b.deadlines, key)
+	return nil
+}
+
+// watch calls fn with every key written or removed through the hub, until the returned function is called.
+// fn is called with the lock held, so it must not use the database.
+func (b *ttlBackend) watch(fn func(key string)) CancelFunc {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	id := b.watcherID
+	b.watcherID++
+	b.watchers[id] = fn
+	return func() {
+		b.mu.Lock()
+		defer b.mu.Unlock()
+		delete(b.watchers, id)
+	}
+}
+
+// changed tells watchers about a written or removed key, must be called with the lock held
+func (b *ttlBackend) changed(key string) {
+	for _, fn := range b.watchers {
+		fn(key)
+	}
+}
+
+// Sweep removes every expired key through db, so subscribers are notified like with any other removal. Each key is
+// written again with its (past) expiration, on condition that the expiration didn't change in the meantime, so keys
+// written again since they expired are left alone.
+func (b *ttlBackend) Sweep(db *LocalDBClient) (int, error) {
+	b.mu.Lock()
+	now := time.Now()
+	expired := make(map[string]string)
+	for key := range b.deadlines {
+		if !b.expired(key, now) {
+			continue
+		}
+		expiry, err := b.Driver.Get(ExpiryPrefix + key)
+		if err != nil {
+			b.mu.Unlock()
+			return 0, err
+		}
+		expired[key] = expiry
+	}
+	b.mu.Unlock()
+
+	removed := 0
+	for key, expiry := range expired {
+		err := db.commit(&Transaction{
+			db:         db,
+			conditions: map[string]string{ExpiryPrefix + key: expiry},
+			writes:     map[string]string{key: "", ExpiryPrefix + key: expiry},
+		})
+		if errors.Is(err, ErrConditionFailed) {
+			continue
+		}
+		if err != nil {
+			return removed, err
+		}
+		removed++
+	}
+	return removed, nil
+}
+
+// runSweeper periodically removes expired keys through a client of hub, once it's running, until Close is called
+func (b *ttlBackend) runSweeper(hub *kv.Hub, secrets *SecretStore) {
+	defer close(b.done)
+
+	ready := make(chan *LocalDBClient, 1)
+	go func() {
+		db, err := NewLocalClient(hub, secrets, b.logger)
+		if err != nil {
+			b.logger.Error("could not connect to database, expired keys won't be removed", zap.Error(err))
+			return
+		}
+		ready <- db
+	}()
+	var db *LocalDBClient
+	select {
+	case <-b.stop:
+		return
+	case db = <-ready:
+	}
+	defer db.Close()
+
+	ticker := time.NewTicker(sweepInterval)
+	defer ticker.Stop()
+	for {
+		removed, err := b.Sweep(db)
+		if err != nil {
+			b.logger.Error("could not remove expired keys", zap.Error(err))
+		} else if removed > 0 {
+			b.logger.Debug("removed expired keys", zap.Int("keys", removed))
+		}
+
+		select {
+		case <-b.stop:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Close stops the sweeper, waiting for a running sweep to finish so the database can be closed safely
+func (b *ttlBackend) Close() {
+	b.stopOnce.Do(func() {
+		close(b.stop)
+	})
+	<-b.done
+}
@@ -0,0 +1,94 @@
+package database
+
+import (
+	"reflect"
+	"strconv"
+	"testing"
+	"time"
+
+	kv "github.com/strimertul/kilovolt/v9"
+)
+
+func TestSweepExpiredKeys(t *testing.T) {
+	RegisterTTL("test/ttl/", 10*time.Millisecond)
+
+	driver := newTestMemoryDatabase(t)
+	go driver.Hub().Run()
+	db := newTestLocalClient(t, driver)
+
+	notified := make(chan string, 10)
+	err, cancel := db.SubscribePrefix(func(key string, value string) {
+		if value == "" {
+			notified <- key
+		}
+	}, "test/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cancel()
+
+	if err = db.PutKeyWithTTL("test/event", "1", 10*time.Millisecond); err != nil {
+		t.Fatal(err)
+	}
+	if err = db.PutKey("test/ttl/rpc", "2"); err != nil {
+		t.Fatal(err)
+	}
+	// Written again without a TTL, so it must stay
+	if err = db.PutKeyWithTTL("test/kept", "3", 10*time.Millisecond); err != nil {
+		t.Fatal(err)
+	}
+	if err = db.PutKey("test/kept", "4"); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(20 * time.Millisecond)
+
+	// Expired keys are hidden even before being swept
+	if value, err := db.GetKey("test/event"); err != nil || value != "" {
+		t.Fatalf("expired key read as %q (%v)", value, err)
+	}
+
+	if _, err = driver.expiry.Sweep(db); err != nil {
+		t.Fatalf("sweep failed: %s", err)
+	}
+	expected := map[string]string{"test/kept": "4"}
+	if state := readTestKeys(t, driver); !reflect.DeepEqual(state, expected) {
+		t.Fatalf("database after sweep is %v, expected %v", state, expected)
+	}
+
+	// Subscribers must know the keys are gone
+	removed := make(map[string]bool)
+	timeout := time.After(time.Second)
+	for len(removed) < 2 {
+		select {
+		case key := <-notified:
+			removed[key] = true
+		case <-timeout:
+			t.Fatalf("subscribers were only notified of %v", removed)
+		}
+	}
+	if !removed["test/event"] || !removed["test/ttl/rpc"] {
+		t.Fatalf("subscribers were notified of %v", removed)
+	}
+}
+
+func TestWriteExpiredKey(t *testing.T) {
+	driver := newTestMemoryDatabase(t)
+	go driver.Hub().Run()
+	db := newTestLocalClient(t, driver)
+
+	if err := db.PutKey("test/key", "1"); err != nil {
+		t.Fatal(err)
+	}
+	// Writing a key with an expiration in the past removes it
+	past := strconv.FormatInt(time.Now().Add(-time.Second).UnixMilli(), 10)
+	_, err := db.makeRequest(kv.CmdWriteBulk, map[string]interface{}{
+		"test/key":                "2",
+		ExpiryPrefix + "test/key": past,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if state := readTestKeys(t, driver); len(state) != 0 {
+		t.Fatalf("expired write was stored: %v", state)
+	}
+}
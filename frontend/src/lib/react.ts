@@ -9,6 +9,7 @@ import {
   KilovoltMessage,
   SubscriptionHandler,
 } from '@strimertul/kilovolt-client';
+import { GetEnvOverrides, GetLogTail } from '@wailsapp/go/main/App';
 import { RootState, useAppDispatch } from '~/store';
 import apiReducer, { getUserPoints } from '~/store/api/reducer';
 import {
@@ -44,6 +45,62 @@ export function useLiveKey<T>(key: string): T {
   return data ? (JSON.parse(data) as T) : null;
 }
 
+/**
+ * Get the last entries of an event log and keep them updated
+ * @param prefix Prefix the log is stored under (eg. twitch/eventsub-log/)
+ * @param size How many entries to keep
+ * @returns Decoded entries, oldest first
+ */
+export function useLiveLog<T>(prefix: string, size: number): T[] {
+  const client = useSelector((state: RootState) => state.api.client);
+  const [entries, setEntries] = useState<T[]>(null);
+
+  useEffect(() => {
+    const subscriber: SubscriptionHandler = (value) => {
+      // Entries removed to make room for new ones are notified as empty
+      if (!value) {
+        return;
+      }
+      setEntries((current) =>
+        [...(current ?? []), JSON.parse(value) as T].slice(-size),
+      );
+    };
+    void GetLogTail(prefix, size).then((tail) => {
+      const parsed = tail.map((value) => JSON.parse(value) as T);
+      setEntries((current) => [...parsed, ...(current ?? [])].slice(-size));
+    });
+    void client.subscribePrefix(prefix, subscriber);
+    return () => {
+      void client.unsubscribePrefix(prefix, subscriber);
+    };
+  }, []);
+
+  return entries;
+}
+
+/**
+ * Get the fields of a key that are set by environment variables, changing them from the dashboard has no effect
+ * @param key Key holding the fields (eg. http/config)
+ * @returns Name of the variable setting each locked field, by field name
+ */
+export function useEnvLocked(key: string): Record<string, string> {
+  const [locked, setLocked] = useState<Record<string, string>>({});
+
+  useEffect(() => {
+    void GetEnvOverrides().then((overrides) => {
+      setLocked(
+        Object.fromEntries(
+          overrides
+            .filter((override) => override.key === key)
+            .map((override) => [override.field, override.variable]),
+        ),
+      );
+    });
+  }, [key]);
+
+  return locked;
+}
+
 export function useModule<T>({
   key,
   selector,
@@ -138,6 +195,8 @@ export function useUserPoints(): LoyaltyStorage {
 }
 
 export default {
+  useEnvLocked,
+  useLiveLog,
   useModule,
   useStatus,
   useUserPoints,
@@ -0,0 +1,42 @@
+import { GetSecret } from '@wailsapp/go/main/App';
+
+/**
+ * Placeholder returned by kilovolt in place of secrets (tokens, passwords).
+ * Saving it back leaves the stored secret unchanged.
+ */
+export const secretMask = '********';
+
+/**
+ * Check if a value is a masked secret
+ * @param value Value read from kilovolt
+ */
+export function isMasked(value: string): boolean {
+  return value === secretMask;
+}
+
+/**
+ * Read a key with its secrets in clear, only available to the dashboard
+ * @param key Key to read
+ * @returns Decoded JSON object
+ */
+export async function getSecretJSON<T>(key: string): Promise<T> {
+  return JSON.parse(await GetSecret(key)) as T;
+}
+
+/**
+ * Get the clear value of a secret field, reading it from the backend if masked
+ * @param key Key the field belongs to
+ * @param field Field containing the secret
+ * @param value Current value of the field
+ */
+export async function revealSecret<T>(
+  key: string,
+  field: keyof T,
+  value: string,
+): Promise<string> {
+  if (!isMasked(value)) {
+    return value;
+  }
+  const stored = await getSecretJSON<T>(key);
+  return stored[field] as unknown as string;
+}
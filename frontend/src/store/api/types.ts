@@ -3,21 +3,26 @@
 import KilovoltWS from '@strimertul/kilovolt-client';
 import type { kvError } from '@strimertul/kilovolt-client/types/messages';
 
-interface HTTPConfig {
+export interface HTTPConfig {
   bind: string;
+  public_bind: string;
   enable_static_server: boolean;
   kv_password: string;
   path: string;
+  tls_enabled: boolean;
+  tls_cert: string;
+  tls_key: string;
+  enable_debug: boolean;
 }
 
-interface TwitchConfig {
+export interface TwitchConfig {
   enabled: boolean;
   enable_bot: boolean;
   api_client_id: string;
   api_client_secret: string;
 }
 
-interface TwitchBotConfig {
+export interface TwitchBotConfig {
   username: string;
   oauth: string;
   channel: string;
@@ -5,7 +5,7 @@ import {
   EventSubNotificationType,
   unwrapEvent,
 } from '~/lib/eventSub';
-import { useLiveKey } from '~/lib/react';
+import { useLiveKey, useLiveLog } from '~/lib/react';
 import { useAppSelector } from '~/store';
 import { PageContainer, SectionHeader, styled, TextBlock } from '../theme';
 import WIPNotice from '../components/utils/WIPNotice';
@@ -390,8 +390,9 @@ function TwitchSection() {
   const { t } = useTranslation();
   const twitchInfo = useLiveKey<StreamInfo[]>('twitch/stream-info');
   // const twitchActivity = useLiveKey<StreamInfo[]>('twitch/chat-activity');
-  const twitchEvents = useLiveKey<EventSubNotification[]>(
-    'twitch/eventsub-history',
+  const twitchEvents = useLiveLog<EventSubNotification>(
+    'twitch/eventsub-log/',
+    100,
   );
 
   return (
@@ -1,7 +1,10 @@
 import React, { useState } from 'react';
 import { useTranslation } from 'react-i18next';
 import { useSelector } from 'react-redux';
+import { GetDatabaseStats } from '@wailsapp/go/main/App';
+import { database } from '@wailsapp/go/models';
 import { RootState } from '~/store';
+import DefinitionTable from '../components/DefinitionTable';
 import {
   Button,
   Field,
@@ -33,6 +36,64 @@ const DisclaimerParagraph = styled('p', {
   margin: '2rem 1rem',
 });
 
+function formatSize(bytes: number): string {
+  const units = ['B', 'KiB', 'MiB', 'GiB'];
+  let size = bytes;
+  let unit = 0;
+  while (size >= 1024 && unit < units.length - 1) {
+    size /= 1024;
+    unit += 1;
+  }
+  return `${unit === 0 ? size : size.toFixed(1)} ${units[unit]}`;
+}
+
+function DatabaseStats({ stats }: { stats: database.DatabaseStats }) {
+  const { t } = useTranslation();
+
+  const summary: Record<string, string> = {
+    [t('pages.debug.stats.keys')]: stats.keys.toString(),
+    [t('pages.debug.stats.size')]: formatSize(stats.size),
+    [t('pages.debug.stats.last-backup')]: stats.last_backup
+      ? new Date(stats.last_backup as string).toLocaleString()
+      : t('pages.debug.stats.no-backups'),
+  };
+  if (stats.storage) {
+    summary[t('pages.debug.stats.disk-size')] = formatSize(
+      stats.storage.disk_size,
+    );
+    summary[t('pages.debug.stats.reclaimable')] = formatSize(
+      stats.storage.reclaimable,
+    );
+    summary[t('pages.debug.stats.compaction-debt')] = formatSize(
+      stats.storage.compaction_debt,
+    );
+    summary[t('pages.debug.stats.wal')] = `${formatSize(
+      stats.storage.wal_size,
+    )} (${stats.storage.wal_files})`;
+  }
+
+  return (
+    <>
+      <DefinitionTable entries={summary} />
+      <Label>{t('pages.debug.stats.prefixes')}</Label>
+      <DefinitionTable
+        entries={Object.fromEntries(
+          stats.prefixes.map((prefix) => [
+            prefix.prefix,
+            `${formatSize(prefix.size)} (${prefix.keys})`,
+          ]),
+        )}
+      />
+      <Label>{t('pages.debug.stats.largest-keys')}</Label>
+      <DefinitionTable
+        entries={Object.fromEntries(
+          stats.largest_keys.map((key) => [key.key, formatSize(key.size)]),
+        )}
+      />
+    </>
+  );
+}
+
 export default function DebugPage(): React.ReactElement {
   const { t } = useTranslation();
   const [warningDismissed, setWarningDismissed] = useState(false);
@@ -41,6 +102,8 @@ export default function DebugPage(): React.ReactElement {
   const [writeKey, setWriteKey] = useState('');
   const [writeValue, setWriteValue] = useState('');
   const [writeErrorMsg, setWriteErrorMsg] = useState<string>(null);
+  const [stats, setStats] = useState<database.DatabaseStats>(null);
+  const [statsErrorMsg, setStatsErrorMsg] = useState<string>(null);
   const api = useSelector((state: RootState) => state.api.client);
 
   const performRead = async () => {
@@ -66,6 +129,14 @@ export default function DebugPage(): React.ReactElement {
   const dumpAll = async () => {
     console.log(await api.getKeysByPrefix(''));
   };
+  const collectStats = async () => {
+    try {
+      setStats(await GetDatabaseStats());
+      setStatsErrorMsg(null);
+    } catch (e: unknown) {
+      setStatsErrorMsg(e instanceof Error ? e.message : String(e));
+    }
+  };
 
   if (!warningDismissed) {
     return (
@@ -165,6 +236,22 @@ export default function DebugPage(): React.ReactElement {
           {writeErrorMsg && <FieldNote>{writeErrorMsg}</FieldNote>}
         </Field>
       </form>
+
+      <Field size="fullWidth">
+        <Label>{t('pages.debug.stats.title')}</Label>
+        <FlexRow align="left" spacing="1">
+          <Button
+            type="button"
+            onClick={() => {
+              void collectStats();
+            }}
+          >
+            {t('pages.debug.stats.collect')}
+          </Button>
+        </FlexRow>
+        {statsErrorMsg && <FieldNote>{statsErrorMsg}</FieldNote>}
+        {stats && <DatabaseStats stats={stats} />}
+      </Field>
     </PageContainer>
   );
 }
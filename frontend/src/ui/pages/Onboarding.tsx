@@ -11,6 +11,8 @@ import { useEffect, useState } from 'react';
 import { Trans, useTranslation } from 'react-i18next';
 import { useNavigate } from 'react-router-dom';
 import { useModule } from '~/lib/react';
+import { revealSecret } from '~/lib/secrets';
+import { TwitchConfig } from '~/store/api/types';
 import { checkTwitchKeys, TwitchCredentials } from '~/lib/twitch';
 import { languages } from '~/locale/languages';
 import { RootState, useAppDispatch } from '~/store';
@@ -253,7 +255,11 @@ function TwitchIntegrationStep() {
       try {
         await checkTwitchKeys(
           twitchConfig.api_client_id,
-          twitchConfig.api_client_secret,
+          await revealSecret<TwitchConfig>(
+            'twitch/config',
+            'api_client_secret',
+            twitchConfig.api_client_secret,
+          ),
         );
         void dispatch(
           setTwitchConfig({
@@ -475,7 +481,11 @@ function TwitchEventsStep() {
         setBotConfig({
           ...botConfig,
           username: userStatus.login,
-          oauth: `oauth:${authKeys.access_token}`,
+          oauth: `oauth:${await revealSecret<TwitchCredentials>(
+            'twitch/auth-keys',
+            'access_token',
+            authKeys.access_token,
+          )}`,
           channel: userStatus.login,
           chat_history: 5,
         }),
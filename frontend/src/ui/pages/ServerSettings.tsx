@@ -1,14 +1,20 @@
+import { CheckIcon } from '@radix-ui/react-icons';
 import React, { useState } from 'react';
 import { useTranslation } from 'react-i18next';
-import { useModule, useStatus } from '~/lib/react';
+import { useEnvLocked, useModule, useStatus } from '~/lib/react';
+import { revealSecret } from '~/lib/secrets';
 import { useAppDispatch } from '~/store';
 import apiReducer, { modules } from '~/store/api/reducer';
+import { HTTPConfig } from '~/store/api/types';
 import AlertContent from '../components/AlertContent';
 import RevealLink from '../components/utils/RevealLink';
 import SaveButton from '../components/forms/SaveButton';
 import {
+  Checkbox,
+  CheckboxIndicator,
   Field,
   FieldNote,
+  FlexRow,
   InputBox,
   Label,
   PageContainer,
@@ -29,8 +35,27 @@ export default function ServerSettingsPage(): React.ReactElement {
     loadStatus.load?.type !== 'success' || loadStatus.save?.type === 'pending';
   const [revealKVPassword, setRevealKVPassword] = useState(false);
   const [showKilovoltWarning, setShowKilovoltWarning] = useState(false);
+  const envLocked = useEnvLocked('http/config');
 
-  const insecureKilovolt = (serverConfig?.kv_password ?? '').length < 1;
+  const insecureKilovolt =
+    !envLocked.kv_password && (serverConfig?.kv_password ?? '').length < 1;
+
+  const toggleKVPassword = async (reveal: boolean) => {
+    // The password is masked when read, get the real one to show it
+    if (reveal && serverConfig) {
+      dispatch(
+        apiReducer.actions.httpConfigChanged({
+          ...serverConfig,
+          kv_password: await revealSecret<HTTPConfig>(
+            'http/config',
+            'kv_password',
+            serverConfig.kv_password,
+          ),
+        }),
+      );
+    }
+    setRevealKVPassword(reveal);
+  };
 
   return (
     <PageContainer>
@@ -54,8 +79,8 @@ export default function ServerSettingsPage(): React.ReactElement {
             id="bind"
             placeholder={t('pages.http.bind-placeholder')}
             value={serverConfig?.bind ?? ''}
-            disabled={busy}
-            required={true}
+            disabled={busy || !!envLocked.bind}
+            required={!envLocked.bind}
             onChange={(e) =>
               dispatch(
                 apiReducer.actions.httpConfigChanged({
@@ -65,19 +90,49 @@ export default function ServerSettingsPage(): React.ReactElement {
               )
             }
           />
-          <FieldNote>{t('pages.http.bind-help')}</FieldNote>
+          <FieldNote>
+            {envLocked.bind
+              ? t('special.env-locked', { variable: envLocked.bind })
+              : t('pages.http.bind-help')}
+          </FieldNote>
+        </Field>
+        <Field size="fullWidth">
+          <Label htmlFor="public-bind">{t('pages.http.public-bind')}</Label>
+          <InputBox
+            type="text"
+            id="public-bind"
+            placeholder={t('pages.http.public-bind-placeholder')}
+            value={serverConfig?.public_bind ?? ''}
+            disabled={busy || !!envLocked.public_bind}
+            onChange={(e) =>
+              dispatch(
+                apiReducer.actions.httpConfigChanged({
+                  ...serverConfig,
+                  public_bind: e.target.value,
+                }),
+              )
+            }
+          />
+          <FieldNote>
+            {envLocked.public_bind
+              ? t('special.env-locked', { variable: envLocked.public_bind })
+              : t('pages.http.public-bind-help')}
+          </FieldNote>
         </Field>
         <Field size="fullWidth">
           <Label htmlFor="kvpassword">
             {t('pages.http.kilovolt-password')}
-            <RevealLink value={revealKVPassword} setter={setRevealKVPassword} />
+            <RevealLink
+              value={revealKVPassword}
+              setter={(reveal) => void toggleKVPassword(reveal)}
+            />
           </Label>{' '}
           <PasswordInputBox
             reveal={revealKVPassword}
             id="kvpassword"
             placeholder={t('pages.http.kilovolt-placeholder')}
             value={serverConfig?.kv_password ?? ''}
-            disabled={busy}
+            disabled={busy || !!envLocked.kv_password}
             autoComplete="off"
             onChange={(e) => {
               dispatch(
@@ -88,7 +143,11 @@ export default function ServerSettingsPage(): React.ReactElement {
               );
             }}
           />
-          <FieldNote>{t('pages.http.kilovolt-placeholder')}</FieldNote>
+          <FieldNote>
+            {envLocked.kv_password
+              ? t('special.env-locked', { variable: envLocked.kv_password })
+              : t('pages.http.kilovolt-placeholder')}
+          </FieldNote>
         </Field>
         <Field size="fullWidth">
           <Label htmlFor="static">{t('pages.http.static-path')}</Label>
@@ -111,10 +170,95 @@ export default function ServerSettingsPage(): React.ReactElement {
           />
           <FieldNote>
             {t('pages.http.static-help', {
-              url: `http://${serverConfig?.bind ?? 'localhost:4337'}/static/`,
+              url: `${serverConfig?.tls_enabled ? 'https' : 'http'}://${
+                serverConfig?.bind ?? 'localhost:4337'
+              }/static/`,
             })}
           </FieldNote>
         </Field>
+        <Field size="fullWidth">
+          <FlexRow spacing={1}>
+            <Checkbox
+              checked={serverConfig?.tls_enabled ?? false}
+              disabled={busy}
+              onCheckedChange={(ev) =>
+                dispatch(
+                  apiReducer.actions.httpConfigChanged({
+                    ...serverConfig,
+                    tls_enabled: !!ev,
+                  }),
+                )
+              }
+              id="tls"
+            >
+              <CheckboxIndicator>
+                {serverConfig?.tls_enabled && <CheckIcon />}
+              </CheckboxIndicator>
+            </Checkbox>
+            <Label htmlFor="tls">{t('pages.http.tls-enable')}</Label>
+          </FlexRow>
+          <FieldNote>{t('pages.http.tls-help')}</FieldNote>
+        </Field>
+        <Field size="fullWidth">
+          <Label htmlFor="tls-cert">{t('pages.http.tls-cert')}</Label>
+          <InputBox
+            type="text"
+            id="tls-cert"
+            placeholder={t('pages.http.tls-cert-placeholder')}
+            disabled={busy || !serverConfig?.tls_enabled}
+            value={serverConfig?.tls_cert ?? ''}
+            onChange={(e) =>
+              dispatch(
+                apiReducer.actions.httpConfigChanged({
+                  ...serverConfig,
+                  tls_cert: e.target.value,
+                }),
+              )
+            }
+          />
+        </Field>
+        <Field size="fullWidth">
+          <Label htmlFor="tls-key">{t('pages.http.tls-key')}</Label>
+          <InputBox
+            type="text"
+            id="tls-key"
+            placeholder={t('pages.http.tls-key-placeholder')}
+            disabled={busy || !serverConfig?.tls_enabled}
+            value={serverConfig?.tls_key ?? ''}
+            onChange={(e) =>
+              dispatch(
+                apiReducer.actions.httpConfigChanged({
+                  ...serverConfig,
+                  tls_key: e.target.value,
+                }),
+              )
+            }
+          />
+          <FieldNote>{t('pages.http.tls-files-help')}</FieldNote>
+        </Field>
+        <Field size="fullWidth">
+          <FlexRow spacing={1}>
+            <Checkbox
+              checked={serverConfig?.enable_debug ?? false}
+              disabled={busy}
+              onCheckedChange={(ev) =>
+                dispatch(
+                  apiReducer.actions.httpConfigChanged({
+                    ...serverConfig,
+                    enable_debug: !!ev,
+                  }),
+                )
+              }
+              id="debug"
+            >
+              <CheckboxIndicator>
+                {serverConfig?.enable_debug && <CheckIcon />}
+              </CheckboxIndicator>
+            </Checkbox>
+            <Label htmlFor="debug">{t('pages.http.debug-enable')}</Label>
+          </FlexRow>
+          <FieldNote>{t('pages.http.debug-help')}</FieldNote>
+        </Field>
         <SaveButton type="submit" status={status} />
         <Alert
           defaultOpen={false}
@@ -5,9 +5,11 @@ import { BrowserOpenURL } from '@wailsapp/runtime/runtime';
 import React, { useEffect, useState } from 'react';
 import { Trans, useTranslation } from 'react-i18next';
 import eventsubTests from '~/data/eventsub-tests';
-import { useModule, useStatus } from '~/lib/react';
+import { useEnvLocked, useModule, useStatus } from '~/lib/react';
 import { useAppDispatch, useAppSelector } from '~/store';
 import apiReducer, { modules } from '~/store/api/reducer';
+import { revealSecret } from '~/lib/secrets';
+import { TwitchConfig } from '~/store/api/types';
 import { checkTwitchKeys } from '~/lib/twitch';
 import BrowserLink from '../components/BrowserLink';
 import DefinitionTable from '../components/DefinitionTable';
@@ -65,6 +67,7 @@ function TwitchBotSettings() {
   const [revealBotToken, setRevealBotToken] = useState(false);
   const active = twitchConfig?.enable_bot ?? false;
   const disabled = !active || status?.type === 'pending';
+  const envLocked = useEnvLocked('twitch/bot-config');
 
   return (
     <form
@@ -148,8 +151,8 @@ function TwitchBotSettings() {
         <PasswordInputBox
           reveal={revealBotToken}
           id="bot-oauth"
-          required={active}
-          disabled={disabled}
+          required={active && !envLocked.oauth}
+          disabled={disabled || !!envLocked.oauth}
           value={botConfig?.oauth ?? ''}
           onChange={(ev) =>
             dispatch(
@@ -161,12 +164,16 @@ function TwitchBotSettings() {
           }
         />
         <FieldNote>
-          <Trans i18nKey="pages.twitch-settings.bot-oauth-note">
-            {' '}
-            <BrowserLink href="https://twitchapps.com/tmi/">
-              https://twitchapps.com/tmi/
-            </BrowserLink>
-          </Trans>
+          {envLocked.oauth ? (
+            t('special.env-locked', { variable: envLocked.oauth })
+          ) : (
+            <Trans i18nKey="pages.twitch-settings.bot-oauth-note">
+              {' '}
+              <BrowserLink href="https://twitchapps.com/tmi/">
+                https://twitchapps.com/tmi/
+              </BrowserLink>
+            </Trans>
+          )}
         </FieldNote>
       </Field>
       <SectionHeader>
@@ -208,6 +215,7 @@ function TwitchAPISettings() {
   const status = useStatus(loadStatus.save);
   const dispatch = useAppDispatch();
   const [revealClientSecret, setRevealClientSecret] = useState(false);
+  const envLocked = useEnvLocked('twitch/config');
   const [testing, setTesting] = useState(false);
   const [testResult, setTestResult] = useState<TestResult>({
     open: false,
@@ -219,7 +227,11 @@ function TwitchAPISettings() {
       try {
         await checkTwitchKeys(
           twitchConfig.api_client_id,
-          twitchConfig.api_client_secret,
+          await revealSecret<TwitchConfig>(
+            'twitch/config',
+            'api_client_secret',
+            twitchConfig.api_client_secret,
+          ),
         );
         setTestResult({ open: true });
       } catch (e: unknown) {
@@ -279,7 +291,8 @@ function TwitchAPISettings() {
           type="text"
           id="clientid"
           placeholder={t('pages.twitch-settings.app-client-id')}
-          required={true}
+          required={!envLocked.api_client_id}
+          disabled={!!envLocked.api_client_id}
           value={twitchConfig?.api_client_id ?? ''}
           onChange={(ev) =>
             dispatch(
@@ -290,6 +303,11 @@ function TwitchAPISettings() {
             )
           }
         />
+        {envLocked.api_client_id && (
+          <FieldNote>
+            {t('special.env-locked', { variable: envLocked.api_client_id })}
+          </FieldNote>
+        )}
       </Field>
 
       <Field size="fullWidth">
@@ -304,7 +322,8 @@ function TwitchAPISettings() {
           reveal={revealClientSecret}
           id="clientsecret"
           placeholder={t('pages.twitch-settings.app-client-secret')}
-          required={true}
+          required={!envLocked.api_client_secret}
+          disabled={!!envLocked.api_client_secret}
           value={twitchConfig?.api_client_secret ?? ''}
           onChange={(ev) =>
             dispatch(
@@ -315,6 +334,13 @@ function TwitchAPISettings() {
             )
           }
         />
+        {envLocked.api_client_secret && (
+          <FieldNote>
+            {t('special.env-locked', {
+              variable: envLocked.api_client_secret,
+            })}
+          </FieldNote>
+        )}
       </Field>
       <ButtonGroup>
         <SaveButton status={status} />
@@ -1,14 +1,23 @@
 // Cynhyrchwyd y ffeil hon yn awtomatig. PEIDIWCH Â MODIWL
 // This file is automatically generated. DO NOT EDIT
+import {database} from '../models';
 import {main} from '../models';
 import {helix} from '../models';
 
 export function AuthenticateKVClient(arg1:string):Promise<void>;
 
+export function GetDatabaseStats():Promise<database.DatabaseStats>;
+
+export function GetEnvOverrides():Promise<Array<database.EnvOverride>>;
+
 export function GetKilovoltBind():Promise<string>;
 
 export function GetLastLogs():Promise<Array<main.LogEntry>>;
 
+export function GetLogTail(arg1:string,arg2:number):Promise<Array<string>>;
+
+export function GetSecret(arg1:string):Promise<string>;
+
 export function GetTwitchAuthURL():Promise<string>;
 
 export function GetTwitchLoggedUser():Promise<helix.User>;
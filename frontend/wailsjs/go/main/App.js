@@ -6,6 +6,14 @@ export function AuthenticateKVClient(arg1) {
   return window['go']['main']['App']['AuthenticateKVClient'](arg1);
 }
 
+export function GetDatabaseStats() {
+  return window['go']['main']['App']['GetDatabaseStats']();
+}
+
+export function GetEnvOverrides() {
+  return window['go']['main']['App']['GetEnvOverrides']();
+}
+
 export function GetKilovoltBind() {
   return window['go']['main']['App']['GetKilovoltBind']();
 }
@@ -14,6 +22,14 @@ export function GetLastLogs() {
   return window['go']['main']['App']['GetLastLogs']();
 }
 
+export function GetLogTail(arg1, arg2) {
+  return window['go']['main']['App']['GetLogTail'](arg1, arg2);
+}
+
+export function GetSecret(arg1) {
+  return window['go']['main']['App']['GetSecret'](arg1);
+}
+
 export function GetTwitchAuthURL() {
   return window['go']['main']['App']['GetTwitchAuthURL']();
 }
@@ -1,3 +1,151 @@
+export namespace database {
+	
+	export class EnvOverride {
+	    key: string;
+	    field: string;
+	    variable: string;
+	
+	    static createFrom(source: any = {}) {
+	        return new EnvOverride(source);
+	    }
+	
+	    constructor(source: any = {}) {
+	        if ('string' === typeof source) source = JSON.parse(source);
+	        this.key = source["key"];
+	        this.field = source["field"];
+	        this.variable = source["variable"];
+	    }
+	}
+	export class KeyStats {
+	    key: string;
+	    size: number;
+	
+	    static createFrom(source: any = {}) {
+	        return new KeyStats(source);
+	    }
+	
+	    constructor(source: any = {}) {
+	        if ('string' === typeof source) source = JSON.parse(source);
+	        this.key = source["key"];
+	        this.size = source["size"];
+	    }
+	}
+	export class LevelMetrics {
+	    level: number;
+	    files: number;
+	    size: number;
+	
+	    static createFrom(source: any = {}) {
+	        return new LevelMetrics(source);
+	    }
+	
+	    constructor(source: any = {}) {
+	        if ('string' === typeof source) source = JSON.parse(source);
+	        this.level = source["level"];
+	        this.files = source["files"];
+	        this.size = source["size"];
+	    }
+	}
+	export class StorageMetrics {
+	    disk_size: number;
+	    reclaimable: number;
+	    levels: LevelMetrics[];
+	    compaction_debt: number;
+	    wal_files: number;
+	    wal_size: number;
+	
+	    static createFrom(source: any = {}) {
+	        return new StorageMetrics(source);
+	    }
+	
+	    constructor(source: any = {}) {
+	        if ('string' === typeof source) source = JSON.parse(source);
+	        this.disk_size = source["disk_size"];
+	        this.reclaimable = source["reclaimable"];
+	        this.levels = this.convertValues(source["levels"], LevelMetrics);
+	        this.compaction_debt = source["compaction_debt"];
+	        this.wal_files = source["wal_files"];
+	        this.wal_size = source["wal_size"];
+	    }
+
+		convertValues(a: any, classs: any, asMap: boolean = false): any {
+		    if (!a) {
+		        return a;
+		    }
+		    if (a.slice) {
+		        return (a as any[]).map(elem => this.convertValues(elem, classs));
+		    } else if ("object" === typeof a) {
+		        if (asMap) {
+		            for (const key of Object.keys(a)) {
+		                a[key] = new classs(a[key]);
+		            }
+		            return a;
+		        }
+		        return new classs(a);
+		    }
+		    return a;
+		}
+	}
+	export class PrefixStats {
+	    prefix: string;
+	    keys: number;
+	    size: number;
+	
+	    static createFrom(source: any = {}) {
+	        return new PrefixStats(source);
+	    }
+	
+	    constructor(source: any = {}) {
+	        if ('string' === typeof source) source = JSON.parse(source);
+	        this.prefix = source["prefix"];
+	        this.keys = source["keys"];
+	        this.size = source["size"];
+	    }
+	}
+	export class DatabaseStats {
+	    keys: number;
+	    size: number;
+	    prefixes: PrefixStats[];
+	    largest_keys: KeyStats[];
+	    storage: StorageMetrics;
+	    // Go type: Time
+	    last_backup: any;
+	
+	    static createFrom(source: any = {}) {
+	        return new DatabaseStats(source);
+	    }
+	
+	    constructor(source: any = {}) {
+	        if ('string' === typeof source) source = JSON.parse(source);
+	        this.keys = source["keys"];
+	        this.size = source["size"];
+	        this.prefixes = this.convertValues(source["prefixes"], PrefixStats);
+	        this.largest_keys = this.convertValues(source["largest_keys"], KeyStats);
+	        this.storage = this.convertValues(source["storage"], StorageMetrics);
+	        this.last_backup = this.convertValues(source["last_backup"], null);
+	    }
+
+		convertValues(a: any, classs: any, asMap: boolean = false): any {
+		    if (!a) {
+		        return a;
+		    }
+		    if (a.slice) {
+		        return (a as any[]).map(elem => this.convertValues(elem, classs));
+		    } else if ("object" === typeof a) {
+		        if (asMap) {
+		            for (const key of Object.keys(a)) {
+		                a[key] = new classs(a[key]);
+		            }
+		            return a;
+		        }
+		        return new classs(a);
+		    }
+		    return a;
+		}
+	}
+
+}
+
 export namespace helix {
 	
 	export class User {
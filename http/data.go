@@ -1,10 +1,32 @@
 package http
 
+import "github.com/strimertul/strimertul/database"
+
 const ServerConfigKey = "http/config"
 
 type ServerConfig struct {
 	Bind               string `json:"bind"`
+	PublicBind         string `json:"public_bind"` // Address for overlays (static files and read-only kilovolt), empty to disable
 	EnableStaticServer bool   `json:"enable_static_server"`
 	Path               string `json:"path"`
 	KVPassword         string `json:"kv_password"`
+	TLSEnabled         bool   `json:"tls_enabled"`
+	TLSCert            string `json:"tls_cert"`     // Path to the certificate (PEM), leave empty with TLSKey to use a self-signed one
+	TLSKey             string `json:"tls_key"`      // Path to the key of the certificate (PEM)
+	EnableDebug        bool   `json:"enable_debug"` // Serve pprof and diagnostics under /debug/, for clients with the kilovolt password
+}
+
+func init() {
+	// Keep credentials out of exports
+	database.RegisterSecret(ServerConfigKey, "kv_password")
+
+	// Let containers set these without storing them
+	database.RegisterEnvOverride(
+		database.EnvOverride{Key: ServerConfigKey, Field: "bind", Variable: "STRIMERTUL_HTTP_BIND"},
+		database.EnvOverride{Key: ServerConfigKey, Field: "public_bind", Variable: "STRIMERTUL_HTTP_PUBLIC_BIND"},
+		database.EnvOverride{Key: ServerConfigKey, Field: "kv_password", Variable: "STRIMERTUL_KV_PASSWORD"},
+	)
+
+	// Let the database check know what this key holds
+	database.RegisterSchema(database.KeySchema{Key: ServerConfigKey, Value: ServerConfig{}})
 }
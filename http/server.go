@@ -8,8 +8,9 @@ import (
 	"fmt"
 	"io/fs"
 	mrand "math/rand"
+	"net"
 	"net/http"
-	"net/http/pprof"
+	"sync/atomic"
 
 	"git.sr.ht/~hamcha/containers/sync"
 	jsoniter "github.com/json-iterator/go"
@@ -26,11 +27,16 @@ type Server struct {
 	db              *database.LocalDBClient
 	logger          *zap.Logger
 	server          *http.Server
+	public          *http.Server
 	frontend        fs.FS
 	hub             *kv.Hub
+	certDir         string
 	mux             *http.ServeMux
+	publicMux       *http.ServeMux
 	requestedRoutes *sync.Map[string, http.Handler]
 	cancelConfigSub database.CancelFunc
+	adminClients    atomic.Int64
+	publicClients   atomic.Int64
 }
 
 func NewServer(db *database.LocalDBClient, logger *zap.Logger) (*Server, error) {
@@ -38,30 +44,30 @@ func NewServer(db *database.LocalDBClient, logger *zap.Logger) (*Server, error)
 		logger:          logger,
 		db:              db,
 		server:          &http.Server{},
+		publicMux:       http.NewServeMux(),
 		requestedRoutes: sync.NewMap[string, http.Handler](),
 		Config:          sync.NewRWSync(ServerConfig{}),
 	}
 
 	var config ServerConfig
-	err := db.GetJSON(ServerConfigKey, &config)
+	err := db.GetSecretJSON(ServerConfigKey, &config)
 	if err != nil {
 		if err != database.ErrEmptyKey {
 			logger.Warn("HTTP config is corrupted or could not be read", zap.Error(err))
 		}
 		// Initialize with default config
-		server.Config.Set(ServerConfig{
-			Bind:               "localhost:4337",
-			EnableStaticServer: false,
-			KVPassword:         generatePassword(),
-		})
+		config = DefaultServerConfig()
 		// Save
-		err = db.PutJSON(ServerConfigKey, server.Config.Get())
+		err = db.PutJSON(ServerConfigKey, config)
 		if err != nil {
 			return nil, err
 		}
-	} else {
-		server.Config.Set(config)
 	}
+	// Environment overrides are applied after saving, they must never end up in the database
+	if err = database.ApplyEnvOverrides(ServerConfigKey, &config); err != nil {
+		return nil, err
+	}
+	server.Config.Set(config)
 
 	// Set hub
 	server.hub = db.Hub()
@@ -74,6 +80,15 @@ func NewServer(db *database.LocalDBClient, logger *zap.Logger) (*Server, error)
 	return server, nil
 }
 
+// DefaultServerConfig is the config used when none is stored, with a new random kilovolt password
+func DefaultServerConfig() ServerConfig {
+	return ServerConfig{
+		Bind:               "localhost:4337",
+		EnableStaticServer: false,
+		KVPassword:         generatePassword(),
+	}
+}
+
 // StatusData contains status info for the HTTP module
 type StatusData struct {
 	Bind string
@@ -84,6 +99,9 @@ func (s *Server) Close() error {
 		s.cancelConfigSub()
 	}
 
+	if s.public != nil {
+		_ = s.public.Close()
+	}
 	return s.server.Close()
 }
 
@@ -91,25 +109,26 @@ func (s *Server) SetFrontend(files fs.FS) {
 	s.frontend = files
 }
 
+// SetCertificateDir sets where the self-signed certificate is generated, when TLS is enabled without one
+func (s *Server) SetCertificateDir(dir string) {
+	s.certDir = dir
+}
+
 func (s *Server) makeMux() *http.ServeMux {
 	mux := http.NewServeMux()
+	config := s.Config.Get()
 
-	// Register pprof
-	mux.HandleFunc("/debug/pprof/", pprof.Index)
-	mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
-	mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
-	mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
-	mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
-
+	if config.EnableDebug {
+		s.registerDebugRoutes(mux)
+	}
 	if s.frontend != nil {
 		mux.Handle("/ui/", http.StripPrefix("/ui/", FileServerWithDefault(http.FS(s.frontend))))
 	}
 	if s.hub != nil {
 		mux.HandleFunc("/ws", func(w http.ResponseWriter, r *http.Request) {
-			kv.ServeWs(s.hub, w, r)
+			serveWs(s.hub, s.db.Secrets(), false, &s.adminClients, s.logger, w, r)
 		})
 	}
-	config := s.Config.Get()
 	if config.EnableStaticServer {
 		mux.Handle("/static/", http.StripPrefix("/static/", http.FileServer(http.Dir(config.Path))))
 	}
@@ -120,6 +139,23 @@ func (s *Server) makeMux() *http.ServeMux {
 	return mux
 }
 
+// makePublicMux builds the routes of the public listener: static files and a read-only kilovolt endpoint for overlays
+func (s *Server) makePublicMux() *http.ServeMux {
+	mux := http.NewServeMux()
+
+	if s.hub != nil {
+		mux.HandleFunc("/ws", func(w http.ResponseWriter, r *http.Request) {
+			serveWs(s.hub, s.db.Secrets(), true, &s.publicClients, s.logger, w, r)
+		})
+	}
+	config := s.Config.Get()
+	if config.EnableStaticServer {
+		mux.Handle("/static/", http.StripPrefix("/static/", http.FileServer(http.Dir(config.Path))))
+	}
+
+	return mux
+}
+
 func (s *Server) RegisterRoute(route string, handler http.Handler) {
 	s.requestedRoutes.SetKey(route, handler)
 	s.mux = s.makeMux()
@@ -135,26 +171,41 @@ func (s *Server) Listen() error {
 	restart := sync.NewRWSync(false)
 	exit := make(chan error)
 	var err error
-	err, s.cancelConfigSub = s.db.SubscribeKey(ServerConfigKey, func(value string) {
+	err, s.cancelConfigSub = s.db.SubscribeKey(ServerConfigKey, func(string) {
 		oldConfig := s.Config.Get()
 
+		// The notified value has the password masked, read it again with secrets
 		var config ServerConfig
-		err := json.Unmarshal([]byte(value), &config)
+		err := s.db.GetSecretJSON(ServerConfigKey, &config)
+		if err == nil {
+			err = database.ApplyEnvOverrides(ServerConfigKey, &config)
+		}
 		if err != nil {
 			s.logger.Error("Failed to unmarshal config", zap.Error(err))
 			return
 		}
 
+		// Don't go down because of a bad certificate, keep the old TLS settings until it's fixed
+		tlsChanged := oldConfig.TLSEnabled != config.TLSEnabled || oldConfig.TLSCert != config.TLSCert || oldConfig.TLSKey != config.TLSKey
+		if tlsChanged && config.TLSEnabled {
+			if _, err := s.tlsConfig(config); err != nil {
+				s.logger.Error("Invalid TLS settings, keeping the previous ones", zap.Error(err))
+				config.TLSEnabled, config.TLSCert, config.TLSKey = oldConfig.TLSEnabled, oldConfig.TLSCert, oldConfig.TLSKey
+				tlsChanged = false
+			}
+		}
+
 		s.Config.Set(config)
 		s.mux = s.makeMux()
+		s.publicMux = s.makePublicMux()
 		// Restart hub if password changed
 		if oldConfig.KVPassword != config.KVPassword {
 			s.hub.SetOptions(kv.HubOptions{
 				Password: config.KVPassword,
 			})
 		}
-		// Restart server if bind changed
-		if oldConfig.Bind != config.Bind {
+		// Restart servers if binds or TLS settings changed
+		if oldConfig.Bind != config.Bind || oldConfig.PublicBind != config.PublicBind || tlsChanged {
 			restart.Set(true)
 			err = s.server.Shutdown(context.Background())
 			if err != nil {
@@ -171,13 +222,25 @@ func (s *Server) Listen() error {
 			config := s.Config.Get()
 			s.logger.Info("Starting HTTP server", zap.String("bind", config.Bind))
 			s.mux = s.makeMux()
+			s.publicMux = s.makePublicMux()
 			s.server = &http.Server{
 				Handler: s,
 				Addr:    config.Bind,
 			}
-			s.logger.Info("HTTP server started", zap.String("bind", config.Bind))
-			err := s.server.ListenAndServe()
+			s.public = nil
+			if config.PublicBind != "" {
+				s.public = &http.Server{
+					Handler: http.HandlerFunc(s.servePublic),
+					Addr:    config.PublicBind,
+				}
+				go s.listenPublic(s.public, config)
+			}
+			s.logger.Info("HTTP server started", zap.String("bind", config.Bind), zap.Bool("tls", config.TLSEnabled))
+			err := s.serve(s.server, config)
 			s.logger.Debug("HTTP server died", zap.Error(err))
+			if s.public != nil {
+				_ = s.public.Close()
+			}
 			if err != nil && !errors.Is(err, http.ErrServerClosed) {
 				exit <- err
 				return
@@ -197,7 +260,44 @@ func (s *Server) Listen() error {
 	return <-exit
 }
 
+// listenPublic runs the public listener, which can fail on its own without taking the dashboard down with it
+func (s *Server) listenPublic(server *http.Server, config ServerConfig) {
+	s.logger.Info("Public HTTP server started", zap.String("bind", server.Addr), zap.Bool("tls", config.TLSEnabled))
+	err := s.serve(server, config)
+	if err != nil && !errors.Is(err, http.ErrServerClosed) {
+		s.logger.Error("Public HTTP server stopped", zap.String("bind", server.Addr), zap.Error(err))
+		return
+	}
+	s.logger.Debug("Public HTTP server stopped", zap.String("bind", server.Addr))
+}
+
+// serve accepts connections for a server until it's closed, over TLS if enabled
+func (s *Server) serve(server *http.Server, config ServerConfig) error {
+	if !config.TLSEnabled {
+		return server.ListenAndServe()
+	}
+
+	tlsConfig, err := s.tlsConfig(config)
+	if err != nil {
+		return err
+	}
+	addr := server.Addr
+	if addr == "" {
+		addr = ":https"
+	}
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	return server.Serve(&tlsListener{Listener: listener, config: tlsConfig})
+}
+
 func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	// With a public listener for other machines, the admin one is only for this machine
+	if s.Config.Get().PublicBind != "" && !isLocalRequest(r) {
+		http.Error(w, "the admin address only accepts connections from this machine", http.StatusForbidden)
+		return
+	}
 	// Redirect to /ui/ if root
 	if r.URL.Path == "/" {
 		http.Redirect(w, r, "/ui/", http.StatusFound)
@@ -206,6 +306,20 @@ func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	s.mux.ServeHTTP(w, r)
 }
 
+func (s *Server) servePublic(w http.ResponseWriter, r *http.Request) {
+	s.publicMux.ServeHTTP(w, r)
+}
+
+// isLocalRequest returns true if the request comes from this machine
+func isLocalRequest(r *http.Request) bool {
+	remote, err := net.ResolveTCPAddr("tcp", r.RemoteAddr)
+	if err != nil {
+		return false
+	}
+	local, _ := r.Context().Value(http.LocalAddrContextKey).(net.Addr)
+	return isLocalAddr(remote, local)
+}
+
 func generatePassword() string {
 	b := make([]byte, 21) // To prevent padding characters, keep it a multiple of 3
 	_, err := crand.Read(b)
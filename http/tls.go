@@ -0,0 +1,306 @@
+package http
+
+import (
+	"bufio"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	crand "crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+const (
+	// SelfSignedCertFile is where the self-signed certificate is stored, inside the certificate directory
+	SelfSignedCertFile = "stul-tls.crt"
+	// SelfSignedKeyFile is where the key of the self-signed certificate is stored, inside the certificate directory
+	SelfSignedKeyFile = "stul-tls.key"
+
+	// selfSignedValidity is how long self-signed certificates last, they are replaced a month before expiring
+	selfSignedValidity = 365 * 24 * time.Hour
+	selfSignedRenewal  = 30 * 24 * time.Hour
+)
+
+// tlsFiles returns the certificate and key to use, generating a self-signed certificate if none is configured
+func (s *Server) tlsFiles(config ServerConfig) (certFile string, keyFile string, err error) {
+	if config.TLSCert != "" || config.TLSKey != "" {
+		if config.TLSCert == "" || config.TLSKey == "" {
+			return "", "", errors.New("both a certificate and a key are needed for TLS")
+		}
+		return config.TLSCert, config.TLSKey, nil
+	}
+
+	if s.certDir == "" {
+		return "", "", errors.New("no certificate configured and nowhere to store a self-signed one")
+	}
+	certFile = filepath.Join(s.certDir, SelfSignedCertFile)
+	keyFile = filepath.Join(s.certDir, SelfSignedKeyFile)
+	binds := []string{config.Bind}
+	if config.PublicBind != "" {
+		binds = append(binds, config.PublicBind)
+	}
+	if selfSignedValid(certFile, binds) {
+		return certFile, keyFile, nil
+	}
+	if err = generateSelfSigned(certFile, keyFile, binds); err != nil {
+		return "", "", fmt.Errorf("could not generate self-signed certificate: %w", err)
+	}
+	return certFile, keyFile, nil
+}
+
+// tlsConfig makes the TLS settings for a config, failing if its certificate can't be loaded
+func (s *Server) tlsConfig(config ServerConfig) (*tls.Config, error) {
+	certFile, keyFile, err := s.tlsFiles(config)
+	if err != nil {
+		return nil, err
+	}
+	loader := &certificateLoader{certFile: certFile, keyFile: keyFile}
+	if _, err = loader.GetCertificate(nil); err != nil {
+		return nil, err
+	}
+	return &tls.Config{
+		MinVersion:     tls.VersionTLS12,
+		GetCertificate: loader.GetCertificate,
+	}, nil
+}
+
+// certificateLoader serves a certificate from disk, loading it again when its files change (eg. when renewed)
+type certificateLoader struct {
+	certFile string
+	keyFile  string
+
+	mu      sync.Mutex
+	cert    *tls.Certificate
+	modTime time.Time
+}
+
+func (l *certificateLoader) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	var modTime time.Time
+	for _, file := range []string{l.certFile, l.keyFile} {
+		info, err := os.Stat(file)
+		if err != nil {
+			return l.fallback(err)
+		}
+		if info.ModTime().After(modTime) {
+			modTime = info.ModTime()
+		}
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.cert != nil && modTime.Equal(l.modTime) {
+		return l.cert, nil
+	}
+	cert, err := tls.LoadX509KeyPair(l.certFile, l.keyFile)
+	if err != nil {
+		// Files might be halfway through being replaced, keep serving the old ones
+		if l.cert != nil {
+			return l.cert, nil
+		}
+		return nil, fmt.Errorf("could not load TLS certificate: %w", err)
+	}
+	l.cert = &cert
+	l.modTime = modTime
+	return l.cert, nil
+}
+
+func (l *certificateLoader) fallback(err error) (*tls.Certificate, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.cert != nil {
+		return l.cert, nil
+	}
+	return nil, fmt.Errorf("could not load TLS certificate: %w", err)
+}
+
+// selfSignedValid returns true if there is a self-signed certificate for the bound hosts that isn't about to expire
+func selfSignedValid(certFile string, binds []string) bool {
+	data, err := os.ReadFile(certFile)
+	if err != nil {
+		return false
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return false
+	}
+	cert, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		return false
+	}
+	if !time.Now().Add(selfSignedRenewal).Before(cert.NotAfter) {
+		return false
+	}
+	// Make a new one if strimertul was moved to a host the certificate isn't for
+	for _, bind := range binds {
+		if host, _, err := net.SplitHostPort(bind); err == nil && host != "" {
+			if ip := net.ParseIP(host); ip == nil || !ip.IsUnspecified() {
+				if cert.VerifyHostname(host) != nil {
+					return false
+				}
+			}
+		}
+	}
+	return true
+}
+
+// generateSelfSigned makes a certificate valid for this machine and the hosts strimertul is bound to
+func generateSelfSigned(certFile string, keyFile string, binds []string) error {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), crand.Reader)
+	if err != nil {
+		return err
+	}
+	serial, err := crand.Int(crand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	template := &x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{Organization: []string{"strimertul"}, CommonName: "strimertul"},
+		NotBefore:             now.Add(-time.Hour),
+		NotAfter:              now.Add(selfSignedValidity),
+		KeyUsage:              x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+		DNSNames:              []string{"localhost"},
+		IPAddresses:           []net.IP{net.IPv4(127, 0, 0, 1), net.IPv6loopback},
+	}
+	if hostname, err := os.Hostname(); err == nil && hostname != "" {
+		template.DNSNames = append(template.DNSNames, hostname)
+	}
+	for _, bind := range binds {
+		if host, _, err := net.SplitHostPort(bind); err == nil && host != "" && host != "localhost" {
+			if ip := net.ParseIP(host); ip != nil {
+				if !ip.IsUnspecified() && !ip.IsLoopback() {
+					template.IPAddresses = append(template.IPAddresses, ip)
+				}
+			} else {
+				template.DNSNames = append(template.DNSNames, host)
+			}
+		}
+	}
+
+	der, err := x509.CreateCertificate(crand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		return err
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return err
+	}
+
+	if err = os.MkdirAll(filepath.Dir(certFile), 0o755); err != nil {
+		return err
+	}
+	// Write the key first, so there's never a new certificate with an old key
+	if err = os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0o600); err != nil {
+		return err
+	}
+	return os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0o644)
+}
+
+// tlsListener accepts TLS connections, and plain ones only from this machine: the dashboard can't trust a
+// self-signed certificate and OAuth redirects come from a local browser, but nothing should go over the
+// network in clear
+type tlsListener struct {
+	net.Listener
+	config *tls.Config
+}
+
+func (l *tlsListener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	// Looking at the first byte waits for the client, do it on the first read instead of blocking Accept
+	return &sniffConn{Conn: conn, config: l.config}, nil
+}
+
+var errPlainRemote = errors.New("plain HTTP connection from another machine refused, TLS is required")
+
+// sniffConn is a connection that switches to TLS if the client starts a TLS handshake
+type sniffConn struct {
+	net.Conn
+	config *tls.Config
+
+	once   sync.Once
+	active net.Conn
+	err    error
+}
+
+func (c *sniffConn) detect() {
+	reader := bufio.NewReader(c.Conn)
+	first, err := reader.Peek(1)
+	if err != nil {
+		c.err = err
+		return
+	}
+	buffered := &bufferedConn{Conn: c.Conn, reader: reader}
+
+	// 0x16 is the record type of TLS handshakes, the first thing a TLS client sends
+	if first[0] == 0x16 {
+		c.active = tls.Server(buffered, c.config)
+		return
+	}
+	if !isLocalConn(c.Conn) {
+		_, _ = c.Conn.Write([]byte("HTTP/1.0 400 Bad Request\r\n\r\nClient sent an HTTP request to an HTTPS server.\n"))
+		c.err = errPlainRemote
+		return
+	}
+	c.active = buffered
+}
+
+func (c *sniffConn) Read(b []byte) (int, error) {
+	c.once.Do(c.detect)
+	if c.err != nil {
+		return 0, c.err
+	}
+	return c.active.Read(b)
+}
+
+func (c *sniffConn) Write(b []byte) (int, error) {
+	c.once.Do(c.detect)
+	if c.err != nil {
+		return 0, c.err
+	}
+	return c.active.Write(b)
+}
+
+// bufferedConn reads what was peeked at before reading from the connection
+type bufferedConn struct {
+	net.Conn
+	reader *bufio.Reader
+}
+
+func (c *bufferedConn) Read(b []byte) (int, error) {
+	return c.reader.Read(b)
+}
+
+// isLocalConn returns true if the other end of the connection is on this machine
+func isLocalConn(conn net.Conn) bool {
+	return isLocalAddr(conn.RemoteAddr(), conn.LocalAddr())
+}
+
+// isLocalAddr returns true if remote is on this machine, local being the address it connected to
+func isLocalAddr(remoteAddr net.Addr, localAddr net.Addr) bool {
+	remote, ok := remoteAddr.(*net.TCPAddr)
+	if !ok {
+		return false
+	}
+	if remote.IP.IsLoopback() {
+		return true
+	}
+	// Connecting to one of our own addresses (eg. when bound to a LAN address)
+	local, ok := localAddr.(*net.TCPAddr)
+	return ok && local.IP.Equal(remote.IP)
+}
@@ -0,0 +1,198 @@
+package http
+
+import (
+	"bytes"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gorilla/websocket"
+	kv "github.com/strimertul/kilovolt/v9"
+	"go.uber.org/zap"
+
+	"github.com/strimertul/strimertul/database"
+)
+
+// readOnlyCommands are the kilovolt commands available on the public listener, anything that writes is refused
+var readOnlyCommands = map[string]bool{
+	kv.CmdProtoVersion:      true,
+	kv.CmdReadKey:           true,
+	kv.CmdReadBulk:          true,
+	kv.CmdReadPrefix:        true,
+	kv.CmdSubscribeKey:      true,
+	kv.CmdSubscribePrefix:   true,
+	kv.CmdUnsubscribeKey:    true,
+	kv.CmdUnsubscribePrefix: true,
+	kv.CmdListKeys:          true,
+	kv.CmdAuthRequest:       true,
+	kv.CmdAuthChallenge:     true,
+}
+
+// errReadOnly is returned to clients of the public listener trying to write
+const errReadOnly kv.ErrCode = "read-only access"
+
+const (
+	// Same limits kilovolt uses for its own websocket clients
+	wsWriteWait      = 10 * time.Second
+	wsPongWait       = 60 * time.Second
+	wsPingPeriod     = (wsPongWait * 9) / 10
+	wsMaxMessageSize = 512000
+)
+
+var wsUpgrader = websocket.Upgrader{
+	ReadBufferSize:  1024,
+	WriteBufferSize: 1024,
+	// Overlays are served from anywhere (OBS, other websites), same as the admin endpoint
+	CheckOrigin: func(r *http.Request) bool { return true },
+}
+
+// wsClient is a kilovolt websocket client. Unlike kilovolt's own, it encrypts secrets before writes reach the hub
+// (which notifies subscribers with the values as they were sent) and can be limited to reading and subscribing to keys.
+// Both the hub and the read pump send to it, so send is never closed: done tells everyone the client is gone instead.
+type wsClient struct {
+	hub       *kv.Hub
+	conn      *websocket.Conn
+	send      chan []byte
+	done      chan struct{}
+	stopOnce  sync.Once
+	closeOnce sync.Once
+	uid       int64
+	readOnly  bool
+	clients   *atomic.Int64
+	secrets   *database.SecretStore
+	logger    *zap.Logger
+}
+
+// serveWs upgrades a request to a kilovolt client, secrets can be nil to send writes as they are.
+// clients counts the clients registered to the hub, until the hub removes them.
+func serveWs(hub *kv.Hub, secrets *database.SecretStore, readOnly bool, clients *atomic.Int64, logger *zap.Logger, w http.ResponseWriter, r *http.Request) {
+	conn, err := wsUpgrader.Upgrade(w, r, nil)
+	if err != nil {
+		logger.Error("error starting websocket session", zap.Error(err), zap.Bool("read-only", readOnly))
+		return
+	}
+	client := &wsClient{
+		hub:      hub,
+		conn:     conn,
+		send:     make(chan []byte, 256),
+		done:     make(chan struct{}),
+		readOnly: readOnly,
+		clients:  clients,
+		secrets:  secrets,
+		logger:   logger,
+	}
+	clients.Add(1)
+	hub.AddClient(client)
+
+	go client.writePump()
+	go client.readPump()
+}
+
+func (c *wsClient) readPump() {
+	defer func() {
+		c.hub.RemoveClient(c)
+		_ = c.conn.Close()
+	}()
+	c.conn.SetReadLimit(wsMaxMessageSize)
+	_ = c.conn.SetReadDeadline(time.Now().Add(wsPongWait))
+	c.conn.SetPongHandler(func(string) error {
+		return c.conn.SetReadDeadline(time.Now().Add(wsPongWait))
+	})
+	for {
+		_, message, err := c.conn.ReadMessage()
+		if err != nil {
+			if websocket.IsUnexpectedCloseError(err, websocket.CloseNormalClosure, websocket.CloseGoingAway, websocket.CloseAbnormalClosure) {
+				c.logger.Info("abnormal close from kilovolt client", zap.Error(err), zap.String("client", c.conn.RemoteAddr().String()))
+			}
+			return
+		}
+		message = bytes.TrimSpace(message)
+
+		var request kv.Request
+		if err = json.Unmarshal(message, &request); err != nil {
+			c.SendJSON(kv.Error{Error: kv.ErrInvalidFmt, Details: err.Error()})
+			continue
+		}
+		if c.readOnly && !readOnlyCommands[request.CmdName] {
+			c.SendJSON(kv.Error{Error: errReadOnly, Details: "this address only allows reading keys, use the admin address to write", RequestID: request.RequestID})
+			continue
+		}
+		if c.secrets != nil {
+			if message, err = c.secrets.SealRequest(message); err != nil {
+				c.SendJSON(kv.Error{Error: kv.ErrServerError, Details: err.Error(), RequestID: request.RequestID})
+				continue
+			}
+		}
+		c.hub.SendMessage(kv.Message{Client: c, Data: message})
+	}
+}
+
+func (c *wsClient) writePump() {
+	ticker := time.NewTicker(wsPingPeriod)
+	defer func() {
+		ticker.Stop()
+		// Nothing will be written anymore, don't leave senders waiting
+		c.stop()
+		_ = c.conn.Close()
+	}()
+	for {
+		select {
+		case <-c.done:
+			// The hub removed us
+			_ = c.conn.SetWriteDeadline(time.Now().Add(wsWriteWait))
+			_ = c.conn.WriteMessage(websocket.CloseMessage, []byte{})
+			return
+		case message := <-c.send:
+			_ = c.conn.SetWriteDeadline(time.Now().Add(wsWriteWait))
+			if err := c.conn.WriteMessage(websocket.TextMessage, message); err != nil {
+				return
+			}
+		case <-ticker.C:
+			_ = c.conn.SetWriteDeadline(time.Now().Add(wsWriteWait))
+			if err := c.conn.WriteMessage(websocket.PingMessage, nil); err != nil {
+				return
+			}
+		}
+	}
+}
+
+func (c *wsClient) Options() kv.ClientOptions {
+	return kv.ClientOptions{}
+}
+
+// Close is called by the hub once it removed the client
+func (c *wsClient) Close() {
+	c.closeOnce.Do(func() {
+		c.clients.Add(-1)
+	})
+	c.stop()
+}
+
+// stop marks the client as gone, it's safe to call more than once
+func (c *wsClient) stop() {
+	c.stopOnce.Do(func() {
+		close(c.done)
+	})
+}
+
+// SendMessage queues a message for the write pump, messages sent after the client is closed are dropped
+func (c *wsClient) SendMessage(data []byte) {
+	select {
+	case c.send <- data:
+	case <-c.done:
+	}
+}
+
+func (c *wsClient) SendJSON(data interface{}) {
+	message, _ := json.Marshal(data)
+	c.SendMessage(message)
+}
+
+func (c *wsClient) SetUID(uid int64) {
+	c.uid = uid
+}
+
+func (c *wsClient) UID() int64 {
+	return c.uid
+}
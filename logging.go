@@ -1,7 +1,6 @@
 package main
 
 import (
-	"os"
 	"time"
 
 	"git.sr.ht/~hamcha/containers/sync"
@@ -19,13 +18,13 @@ var (
 	incomingLogs chan LogEntry
 )
 
-func initLogger(level zapcore.Level) {
+func initLogger(level zapcore.Level, console zapcore.WriteSyncer) {
 	lastLogs = sync.NewSlice[LogEntry]()
 	incomingLogs = make(chan LogEntry, 100)
 	logStorage := NewLogStorage(level)
 	consoleLogger := zapcore.NewCore(
 		zapcore.NewConsoleEncoder(zap.NewDevelopmentEncoderConfig()),
-		zapcore.Lock(os.Stderr),
+		zapcore.Lock(console),
 		level,
 	)
 	fileLogger := zapcore.NewCore(
@@ -1,6 +1,10 @@
 package loyalty
 
-import "time"
+import (
+	"time"
+
+	"github.com/strimertul/strimertul/database"
+)
 
 const ConfigKey = "loyalty/config"
 
@@ -63,6 +67,27 @@ const (
 	RedeemEvent     = "loyalty/ev/new-redeem"
 )
 
+func init() {
+	// Events and RPC calls don't need to stick around
+	database.RegisterTTL("loyalty/ev/", database.EventTTL)
+	database.RegisterTTL(CreateRedeemRPC, database.RPCTTL)
+	database.RegisterTTL(RemoveRedeemRPC, database.RPCTTL)
+
+	// Let the database check know what these keys hold
+	for key, value := range map[string]interface{}{
+		ConfigKey:       Config{},
+		RewardsKey:      []Reward{},
+		GoalsKey:        []Goal{},
+		PointsPrefix:    PointsEntry{},
+		QueueKey:        []Redeem{},
+		CreateRedeemRPC: Redeem{},
+		RemoveRedeemRPC: Redeem{},
+		RedeemEvent:     Redeem{},
+	} {
+		database.RegisterSchema(database.KeySchema{Key: key, Value: value})
+	}
+}
+
 // Stulbe events
 
 type ExLoyaltyRedeem struct {
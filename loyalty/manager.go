@@ -73,7 +73,7 @@ func NewManager(db *database.LocalDBClient, twitchManager *twitch.Manager, logge
 
 	// Retrieve configs
 	var rewards []Reward
-	if err := db.GetJSON(RewardsKey, &rewards); err != nil {
+	if err := db.GetJSON(RewardsKey, &rewards); err == nil {
 		loyalty.Rewards.Set(rewards)
 	} else {
 		if !errors.Is(err, database.ErrEmptyKey) {
@@ -82,7 +82,7 @@ func NewManager(db *database.LocalDBClient, twitchManager *twitch.Manager, logge
 	}
 
 	var goals []Goal
-	if err := db.GetJSON(GoalsKey, &goals); err != nil {
+	if err := db.GetJSON(GoalsKey, &goals); err == nil {
 		loyalty.Goals.Set(goals)
 	} else {
 		if !errors.Is(err, database.ErrEmptyKey) {
@@ -91,7 +91,7 @@ func NewManager(db *database.LocalDBClient, twitchManager *twitch.Manager, logge
 	}
 
 	var queue []Redeem
-	if err := db.GetJSON(QueueKey, &queue); err != nil {
+	if err := db.GetJSON(QueueKey, &queue); err == nil {
 		loyalty.Queue.Set(queue)
 	} else {
 		if !errors.Is(err, database.ErrEmptyKey) {
@@ -109,6 +109,10 @@ func NewManager(db *database.LocalDBClient, twitchManager *twitch.Manager, logge
 	}
 
 	for k, v := range points {
+		// Skip entries that were "deleted" by older versions
+		if v == "" {
+			continue
+		}
 		var entry PointsEntry
 		err := json.UnmarshalFromString(v, &entry)
 		if err != nil {
@@ -165,12 +169,20 @@ func (m *Manager) update(key, value string) {
 	case QueueKey:
 		err = utils.LoadJSONToWrapped[[]Redeem](value, m.Queue)
 	case CreateRedeemRPC:
+		// The RPC key expired and was removed, there's nothing to do
+		if value == "" {
+			break
+		}
 		var redeem Redeem
 		err = json.UnmarshalFromString(value, &redeem)
 		if err == nil {
 			err = m.AddRedeem(redeem)
 		}
 	case RemoveRedeemRPC:
+		// The RPC key expired and was removed, there's nothing to do
+		if value == "" {
+			break
+		}
 		var redeem Redeem
 		err = json.UnmarshalFromString(value, &redeem)
 		if err == nil {
@@ -181,9 +193,14 @@ func (m *Manager) update(key, value string) {
 		switch {
 		// User point changed
 		case strings.HasPrefix(key, PointsPrefix):
+			user := key[len(PointsPrefix):]
+			// Entry was deleted
+			if value == "" {
+				m.points.DeleteKey(user)
+				break
+			}
 			var entry PointsEntry
 			err = json.UnmarshalFromString(value, &entry)
-			user := key[len(PointsPrefix):]
 			m.points.SetKey(user, entry)
 		}
 	}
@@ -202,34 +219,49 @@ func (m *Manager) GetPoints(user string) int64 {
 	return 0
 }
 
-func (m *Manager) setPoints(user string, points int64) error {
-	entry := PointsEntry{
-		Points: points,
+// changePoints adds (or removes, if negative) points to a user as part of a transaction
+func changePoints(tx *database.Transaction, user string, delta int64) (PointsEntry, error) {
+	var entry PointsEntry
+	if err := tx.GetJSON(PointsPrefix+user, &entry); err != nil && !errors.Is(err, database.ErrEmptyKey) {
+		return entry, err
 	}
-	m.points.SetKey(user, entry)
-	return m.db.PutJSON(PointsPrefix+user, entry)
+	entry.Points += delta
+	return entry, tx.PutJSON(PointsPrefix+user, entry)
 }
 
-func (m *Manager) GivePoints(pointsToGive map[string]int64) error {
-	// Add points to each user
-	for user, points := range pointsToGive {
-		balance := m.GetPoints(user)
-		if err := m.setPoints(user, balance+points); err != nil {
-			return err
+// changePointsBulk changes the balance of multiple users at once, either all of them are updated or none are
+func (m *Manager) changePointsBulk(changes map[string]int64) error {
+	entries := make(map[string]PointsEntry)
+	err := m.db.Transaction(func(tx *database.Transaction) error {
+		for user, delta := range changes {
+			entry, err := changePoints(tx, user, delta)
+			if err != nil {
+				return err
+			}
+			entries[user] = entry
 		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	for user, entry := range entries {
+		m.points.SetKey(user, entry)
 	}
 	return nil
 }
 
+func (m *Manager) GivePoints(pointsToGive map[string]int64) error {
+	return m.changePointsBulk(pointsToGive)
+}
+
 func (m *Manager) TakePoints(pointsToTake map[string]int64) error {
-	// Add points to each user
+	changes := make(map[string]int64)
 	for user, points := range pointsToTake {
-		balance := m.GetPoints(user)
-		if err := m.setPoints(user, balance-points); err != nil {
-			return err
-		}
+		changes[user] = -points
 	}
-	return nil
+	return m.changePointsBulk(changes)
 }
 
 func (m *Manager) saveQueue() error {
@@ -246,22 +278,33 @@ func (m *Manager) GetRewardCooldown(rewardID string) time.Time {
 	return cooldown
 }
 
-func (m *Manager) AddRedeem(redeem Redeem) error {
-	// Add to local list
-	m.Queue.Set(append(m.Queue.Get(), redeem))
+// queueRedeem adds a redeem to the queue and sends the redeem event as part of a transaction, returning the new queue
+func queueRedeem(tx *database.Transaction, redeem Redeem) ([]Redeem, error) {
+	var queue []Redeem
+	if err := tx.GetJSON(QueueKey, &queue); err != nil && !errors.Is(err, database.ErrEmptyKey) {
+		return nil, err
+	}
+	queue = append(queue, redeem)
+	if err := tx.PutJSON(QueueKey, queue); err != nil {
+		return nil, err
+	}
 
 	// Send redeem event
-	if err := m.db.PutJSON(RedeemEvent, redeem); err != nil {
-		return err
-	}
+	return queue, tx.PutJSON(RedeemEvent, redeem)
+}
 
-	// Add cooldown if applicable
-	if redeem.Reward.Cooldown > 0 {
-		m.cooldowns[redeem.Reward.ID] = time.Now().Add(time.Second * time.Duration(redeem.Reward.Cooldown))
+func (m *Manager) AddRedeem(redeem Redeem) error {
+	var queue []Redeem
+	err := m.db.Transaction(func(tx *database.Transaction) (err error) {
+		queue, err = queueRedeem(tx, redeem)
+		return
+	})
+	if err != nil {
+		return err
 	}
 
-	// Save points
-	return m.saveQueue()
+	m.redeemed(redeem, queue)
+	return nil
 }
 
 func (m *Manager) PerformRedeem(redeem Redeem) error {
@@ -270,14 +313,33 @@ func (m *Manager) PerformRedeem(redeem Redeem) error {
 		return ErrRedeemInCooldown
 	}
 
-	// Add redeem
-	err := m.AddRedeem(redeem)
+	// Add redeem and remove points from user, all at once so we never end up with just one of them
+	var queue []Redeem
+	var entry PointsEntry
+	err := m.db.Transaction(func(tx *database.Transaction) (err error) {
+		if queue, err = queueRedeem(tx, redeem); err != nil {
+			return
+		}
+		entry, err = changePoints(tx, redeem.Username, -redeem.Reward.Price)
+		return
+	})
 	if err != nil {
 		return err
 	}
 
-	// Remove points from user
-	return m.TakePoints(map[string]int64{redeem.Username: redeem.Reward.Price})
+	m.points.SetKey(redeem.Username, entry)
+	m.redeemed(redeem, queue)
+	return nil
+}
+
+// redeemed updates the local state after a redeem has been saved
+func (m *Manager) redeemed(redeem Redeem, queue []Redeem) {
+	m.Queue.Set(queue)
+
+	// Add cooldown if applicable
+	if redeem.Reward.Cooldown > 0 {
+		m.cooldowns[redeem.Reward.ID] = time.Now().Add(time.Second * time.Duration(redeem.Reward.Cooldown))
+	}
 }
 
 func (m *Manager) RemoveRedeem(redeem Redeem) error {
@@ -299,29 +361,41 @@ func (m *Manager) SaveGoals() error {
 	return m.db.PutJSON(GoalsKey, m.Goals.Get())
 }
 
-func (m *Manager) ContributeGoal(goal Goal, user string, points int64) error {
-	goals := m.Goals.Get()
+// contributeGoal adds points to a goal as part of a transaction, returning the new goal list
+func contributeGoal(tx *database.Transaction, goalID string, user string, points int64) ([]Goal, error) {
+	var goals []Goal
+	if err := tx.GetJSON(GoalsKey, &goals); err != nil && !errors.Is(err, database.ErrEmptyKey) {
+		return nil, err
+	}
 	for i, savedGoal := range goals {
-		if savedGoal.ID != goal.ID {
+		if savedGoal.ID != goalID {
 			continue
 		}
 		goals[i].Contributed += points
+		if goals[i].Contributors == nil {
+			goals[i].Contributors = make(map[string]int64)
+		}
 		goals[i].Contributors[user] += points
-		m.Goals.Set(goals)
-		return m.SaveGoals()
+		return goals, tx.PutJSON(GoalsKey, goals)
 	}
-	return ErrGoalNotFound
+	return nil, ErrGoalNotFound
 }
 
-func (m *Manager) PerformContribution(goal Goal, user string, points int64) error {
-	// Get user balance
-	balance := m.GetPoints(user)
-
-	// If user specified more points than they have, pick the maximum possible
-	if points > balance {
-		points = balance
+func (m *Manager) ContributeGoal(goal Goal, user string, points int64) error {
+	var goals []Goal
+	err := m.db.Transaction(func(tx *database.Transaction) (err error) {
+		goals, err = contributeGoal(tx, goal.ID, user, points)
+		return
+	})
+	if err != nil {
+		return err
 	}
 
+	m.Goals.Set(goals)
+	return nil
+}
+
+func (m *Manager) PerformContribution(goal Goal, user string, points int64) error {
 	// Check if goal was reached already
 	if goal.Contributed >= goal.TotalGoal {
 		return ErrGoalAlreadyReached
@@ -333,13 +407,36 @@ func (m *Manager) PerformContribution(goal Goal, user string, points int64) erro
 		points = remaining
 	}
 
-	// Remove points from user
-	if err := m.TakePoints(map[string]int64{user: points}); err != nil {
+	// Remove points from user and add them to the goal, all at once so points are never lost
+	var goals []Goal
+	var entry PointsEntry
+	err := m.db.Transaction(func(tx *database.Transaction) error {
+		// Get user balance
+		var balance PointsEntry
+		if err := tx.GetJSON(PointsPrefix+user, &balance); err != nil && !errors.Is(err, database.ErrEmptyKey) {
+			return err
+		}
+
+		// If user specified more points than they have, pick the maximum possible
+		contribution := points
+		if contribution > balance.Points {
+			contribution = balance.Points
+		}
+
+		var err error
+		if entry, err = changePoints(tx, user, -contribution); err != nil {
+			return err
+		}
+		goals, err = contributeGoal(tx, goal.ID, user, contribution)
+		return err
+	})
+	if err != nil {
 		return err
 	}
 
-	// Add points to goal
-	return m.ContributeGoal(goal, user, points)
+	m.points.SetKey(user, entry)
+	m.Goals.Set(goals)
+	return nil
 }
 
 func (m *Manager) GetReward(id string) Reward {
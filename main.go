@@ -2,22 +2,19 @@ package main
 
 import (
 	"context"
-	"embed"
 	"fmt"
 	"log"
 	"math/rand"
 	"os"
+	"os/signal"
+	"syscall"
 	"time"
 
 	"github.com/apenwarr/fixconsole"
 	jsoniter "github.com/json-iterator/go"
 	"github.com/urfave/cli/v2"
-	"github.com/wailsapp/wails/v2"
-	"github.com/wailsapp/wails/v2/pkg/options"
-	"github.com/wailsapp/wails/v2/pkg/options/assetserver"
 	"go.uber.org/zap"
 	"go.uber.org/zap/zapcore"
-	_ "net/http/pprof"
 
 	"github.com/strimertul/strimertul/utils"
 )
@@ -26,9 +23,6 @@ var json = jsoniter.ConfigFastest
 
 var appVersion = "v0.0.0-UNKNOWN"
 
-//go:embed frontend/dist
-var frontend embed.FS
-
 func main() {
 	err := fixconsole.FixConsoleIfNeeded()
 	if err != nil {
@@ -42,40 +36,172 @@ func main() {
 		Action:  cliMain,
 		Flags: []cli.Flag{
 			&cli.StringFlag{Name: "log-level", Usage: "logging level (debug,info,warn,error)", Value: "info"},
-			&cli.StringFlag{Name: "driver", Usage: "specify database driver", Value: "auto"},
+			&cli.StringFlag{Name: "driver", Usage: "specify database driver (auto, pebble, sqlite, memory)", Value: "auto"},
 			&cli.StringFlag{Name: "database-dir", Aliases: []string{"db-dir"}, Usage: "specify database directory", Value: "data"},
 			&cli.StringFlag{Name: "backup-dir", Aliases: []string{"b-dir"}, Usage: "specify backup directory", Value: "backups"},
 			&cli.IntFlag{Name: "backup-interval", Aliases: []string{"b-i"}, Usage: "specify backup interval (in minutes, 0 to disable)", Value: 60},
-			&cli.IntFlag{Name: "max-backups", Aliases: []string{"b-max"}, Usage: "maximum number of backups to keep, older ones will be deleted, set to 0 to keep all", Value: 20},
+			&cli.IntFlag{Name: "max-backups", Aliases: []string{"b-max"}, Usage: "maximum number of full backups to keep (each along with its incremental backups), older ones will be deleted, set to 0 to keep all", Value: 20},
+			&cli.IntFlag{Name: "full-backup-every", Aliases: []string{"b-full"}, Usage: "take a full backup every N backups and incremental ones (only changed keys) in between, set to 1 to always take full backups", Value: 1},
+			&cli.StringFlag{Name: "backup-passphrase", Usage: "encrypt backups with this passphrase (also needed to restore them)", EnvVars: []string{"STRIMERTUL_BACKUP_PASSPHRASE"}},
+			&cli.StringFlag{Name: "config", Usage: "YAML or JSON file with the configuration to apply on startup (see docs/config.md)"},
+			&cli.StringFlag{Name: "config-mode", Usage: "how to apply --config: seed (only set what isn't configured yet) or sync (the file always wins, dashboard changes are written back to it)", Value: configModeSeed},
 		},
 		Commands: []*cli.Command{
+			{
+				Name:  "config",
+				Usage: "manage config files",
+				Subcommands: []*cli.Command{
+					{
+						Name:      "check",
+						Usage:     "validate a config file without applying it",
+						ArgsUsage: "[file]",
+						Action:    cliConfigCheck,
+					},
+					{
+						Name:  "dump",
+						Usage: "print the config stored in the database as a config file, to start one from an existing setup",
+						Flags: []cli.Flag{
+							&cli.BoolFlag{Name: "include-secrets", Usage: "include credentials (tokens, passwords) instead of leaving them out"},
+							&cli.BoolFlag{Name: "json", Usage: "print JSON instead of YAML"},
+						},
+						Action: cliConfigDump,
+					},
+				},
+			},
+			{
+				Name:   "serve",
+				Usage:  "run without the UI window (eg. on a server or in a container), stop with Ctrl+C or SIGTERM",
+				Action: cliServe,
+			},
 			{
 				Name:      "import",
 				Usage:     "import database from JSON file",
-				ArgsUsage: "[-f input.json]",
+				ArgsUsage: "[-f input.json] [--prefix prefix] [--exclude-prefix prefix]",
 				Flags: []cli.Flag{
 					&cli.StringFlag{Name: "file", Aliases: []string{"f"}, Usage: "file to open", DefaultText: "STDIN"},
+					&cli.StringSliceFlag{Name: "prefix", Usage: "only import keys starting with this prefix (can be repeated)"},
+					&cli.StringSliceFlag{Name: "exclude-prefix", Usage: "don't import keys starting with this prefix (can be repeated)"},
 				},
 				Action: cliImport,
 			},
 			{
 				Name:      "export",
 				Usage:     "export database as JSON file",
-				ArgsUsage: "[-f output.json]",
+				ArgsUsage: "[-f output.json] [--prefix prefix] [--exclude-prefix prefix]",
 				Flags: []cli.Flag{
 					&cli.StringFlag{Name: "file", Aliases: []string{"f"}, Usage: "file to save to", DefaultText: "STDOUT"},
+					&cli.StringSliceFlag{Name: "prefix", Usage: "only export keys starting with this prefix (can be repeated)"},
+					&cli.StringSliceFlag{Name: "exclude-prefix", Usage: "don't export keys starting with this prefix (can be repeated)"},
+					&cli.BoolFlag{Name: "include-secrets", Usage: "export credentials (tokens, passwords) instead of leaving them out"},
 				},
 				Action: cliExport,
 			},
 			{
 				Name:      "restore",
-				Usage:     "restore database from backup",
-				ArgsUsage: "[-f backup.db]",
+				Usage:     "restore database from backup (incremental backups are applied on top of their full backup)",
+				ArgsUsage: "[-f backup.db | --at time]",
 				Flags: []cli.Flag{
-					&cli.StringFlag{Name: "file", Aliases: []string{"f"}, Usage: "backup to open", DefaultText: "STDOUT"},
+					&cli.StringFlag{Name: "file", Aliases: []string{"f"}, Usage: "backup to open", DefaultText: "STDIN"},
+					&cli.StringFlag{Name: "at", Usage: "restore the latest backup in the backup directory taken at or before this time (eg. \"2023-02-01 15:04\" or \"2h\" for two hours ago)"},
 				},
 				Action: cliRestore,
 			},
+			{
+				Name:  "backup",
+				Usage: "inspect backups in the backup directory",
+				Subcommands: []*cli.Command{
+					{
+						Name:   "list",
+						Usage:  "list backups with their time, size and key count",
+						Action: cliBackupList,
+					},
+					{
+						Name:      "diff",
+						Usage:     "show keys added, removed or changed between two backups",
+						ArgsUsage: "<old backup> <new backup>",
+						Flags: []cli.Flag{
+							&cli.StringFlag{Name: "prefix", Usage: "only compare keys starting with this prefix (eg. loyalty/points/)"},
+							&cli.BoolFlag{Name: "values", Usage: "also print the values of the keys"},
+						},
+						Action: cliBackupDiff,
+					},
+				},
+			},
+			{
+				Name:      "migrate",
+				Usage:     "copy database to a different driver and switch to it",
+				ArgsUsage: "--to-driver <driver> [--to-dir directory]",
+				Flags: []cli.Flag{
+					&cli.StringFlag{Name: "to-driver", Usage: "driver to migrate to (pebble, sqlite)", Required: true},
+					&cli.StringFlag{Name: "to-dir", Usage: "directory for the new database", DefaultText: "same as database-dir"},
+				},
+				Action: cliMigrate,
+			},
+			{
+				Name:    "compact",
+				Aliases: []string{"vacuum"},
+				Usage:   "purge keys left empty by older versions and reclaim unused disk space",
+				Action:  cliCompact,
+			},
+			{
+				Name:  "check",
+				Usage: "check stored data for corrupt, empty or unknown keys",
+				Flags: []cli.Flag{
+					&cli.BoolFlag{Name: "repair", Usage: "back up the database, then delete corrupt and empty keys so they go back to their defaults"},
+				},
+				Action: cliCheck,
+			},
+			{
+				Name:  "stats",
+				Usage: "show what is taking space in the database",
+				Flags: []cli.Flag{
+					&cli.IntFlag{Name: "depth", Usage: "how many segments of each key to group by (eg. 2 for twitch/config)", Value: 2},
+					&cli.IntFlag{Name: "top", Usage: "how many of the largest keys to show", Value: 10},
+					&cli.BoolFlag{Name: "json", Usage: "print statistics as JSON"},
+				},
+				Action: cliStats,
+			},
+			{
+				Name:  "kv",
+				Usage: "read and write keys in the database directory, or in a running instance with --remote",
+				Flags: []cli.Flag{
+					&cli.StringFlag{Name: "remote", Usage: "address of a running instance (eg. localhost:4337, or wss://host:4337 with TLS), leave empty to use the database directory"},
+					&cli.StringFlag{Name: "password", Usage: "kilovolt password of the running instance", EnvVars: []string{"STRIMERTUL_KV_PASSWORD"}},
+					&cli.StringFlag{Name: "ca-cert", Usage: "certificate to trust when connecting with wss:// (eg. the self-signed stul-tls.crt in the database directory)"},
+				},
+				Subcommands: []*cli.Command{
+					{
+						Name:      "get",
+						Usage:     "print the value of one or more keys",
+						ArgsUsage: "<key> [key...]",
+						Action:    cliKVGet,
+					},
+					{
+						Name:      "put",
+						Usage:     "write a key",
+						ArgsUsage: "<key> <value> (use - as value to read it from STDIN)",
+						Action:    cliKVPut,
+					},
+					{
+						Name:      "list",
+						Usage:     "list keys starting with a prefix",
+						ArgsUsage: "[prefix]",
+						Action:    cliKVList,
+					},
+					{
+						Name:      "watch",
+						Usage:     "print changes to keys starting with a prefix until interrupted (needs --remote)",
+						ArgsUsage: "[prefix]",
+						Action:    cliKVWatch,
+					},
+					{
+						Name:      "del",
+						Usage:     "delete one or more keys",
+						ArgsUsage: "<key> [key...]",
+						Action:    cliKVDelete,
+					},
+				},
+			},
 		},
 		Before: func(ctx *cli.Context) error {
 			// Seed RNG
@@ -86,7 +212,12 @@ func main() {
 			if err != nil {
 				level = zapcore.InfoLevel
 			}
-			initLogger(level)
+			// Logs are the only output of the headless server, send them to STDOUT like services usually do
+			console := os.Stderr
+			if ctx.Args().First() == "serve" {
+				console = os.Stdout
+			}
+			initLogger(level, console)
 			ctx.Context = context.WithValue(ctx.Context, utils.ContextLogger, logger)
 			return nil
 		},
@@ -102,28 +233,27 @@ func main() {
 	}
 }
 
-func cliMain(ctx *cli.Context) error {
-	// Create an instance of the app structure
+func cliServe(ctx *cli.Context) error {
 	app := NewApp(ctx)
+	app.headless = true
 
-	// Create application with options
-	err := wails.Run(&options.App{
-		Title:  "strimertul",
-		Width:  1024,
-		Height: 768,
-		AssetServer: &assetserver.Options{
-			Assets: frontend,
-		},
-		BackgroundColour: &options.RGBA{R: 27, G: 38, B: 54, A: 1},
-		OnStartup:        app.startup,
-		OnShutdown:       app.stop,
-		Bind: []interface{}{
-			app,
-		},
-	})
-	if err != nil {
-		return cli.Exit(fmt.Errorf("%s: %w", "App exited unexpectedly", err), 1)
+	// startup only returns once the HTTP server stops
+	stopped := make(chan struct{})
+	go func() {
+		app.startup(ctx.Context)
+		close(stopped)
+	}()
+
+	interrupt := make(chan os.Signal, 1)
+	signal.Notify(interrupt, os.Interrupt, syscall.SIGTERM)
+	select {
+	case sig := <-interrupt:
+		logger.Info("received signal, shutting down", zap.String("signal", sig.String()))
+	case <-stopped:
 	}
+	signal.Stop(interrupt)
+
+	app.stop(ctx.Context)
 	return nil
 }
 
@@ -0,0 +1,47 @@
+//go:build !headless
+
+package main
+
+import (
+	"context"
+	"embed"
+	"fmt"
+
+	"github.com/urfave/cli/v2"
+	"github.com/wailsapp/wails/v2"
+	"github.com/wailsapp/wails/v2/pkg/options"
+	"github.com/wailsapp/wails/v2/pkg/options/assetserver"
+	"github.com/wailsapp/wails/v2/pkg/runtime"
+)
+
+//go:embed frontend/dist
+var frontend embed.FS
+
+func cliMain(ctx *cli.Context) error {
+	// Create an instance of the app structure
+	app := NewApp(ctx)
+
+	// Create application with options
+	err := wails.Run(&options.App{
+		Title:  "strimertul",
+		Width:  1024,
+		Height: 768,
+		AssetServer: &assetserver.Options{
+			Assets: frontend,
+		},
+		BackgroundColour: &options.RGBA{R: 27, G: 38, B: 54, A: 1},
+		OnStartup:        app.startup,
+		OnShutdown:       app.stop,
+		Bind: []interface{}{
+			app,
+		},
+	})
+	if err != nil {
+		return cli.Exit(fmt.Errorf("%s: %w", "App exited unexpectedly", err), 1)
+	}
+	return nil
+}
+
+func emitUIEvent(ctx context.Context, event string, data interface{}) {
+	runtime.EventsEmit(ctx, event, data)
+}
@@ -0,0 +1,19 @@
+//go:build headless
+
+package main
+
+import (
+	"context"
+	"errors"
+
+	"github.com/urfave/cli/v2"
+)
+
+// Headless builds (go build -tags headless) leave out the dashboard window and its dependencies (Wails, WebKitGTK),
+// only the serve command and the other CLI commands are available
+
+func cliMain(*cli.Context) error {
+	return cli.Exit(errors.New("this build of strimertul has no dashboard window, use the serve command"), 1)
+}
+
+func emitUIEvent(context.Context, string, interface{}) {}
@@ -2,6 +2,7 @@ package twitch
 
 import (
 	"bytes"
+	"errors"
 	"math/rand"
 	"sync"
 	"text/template"
@@ -105,12 +106,16 @@ func SetupAlerts(bot *Bot) *BotAlertsModule {
 	// Load config from database
 	err := bot.api.db.GetJSON(BotAlertsKey, &mod.Config)
 	if err != nil {
-		bot.logger.Debug("config load error", zap.Error(err))
 		mod.Config = BotAlertsConfig{}
-		// Save empty config
-		err = bot.api.db.PutJSON(BotAlertsKey, mod.Config)
-		if err != nil {
-			bot.logger.Warn("could not save default config for bot alerts", zap.Error(err))
+		if errors.Is(err, database.ErrEmptyKey) {
+			// Save empty config
+			err = bot.api.db.PutJSON(BotAlertsKey, mod.Config)
+			if err != nil {
+				bot.logger.Warn("could not save default config for bot alerts", zap.Error(err))
+			}
+		} else {
+			// Don't overwrite data we can't read, it might just need a migration
+			bot.logger.Error("could not read bot alert config, using defaults until it's fixed", zap.Error(err))
 		}
 	}
 
@@ -241,6 +246,10 @@ func SetupAlerts(bot *Bot) *BotAlertsModule {
 	}
 
 	err, mod.cancelTwitchEventSub = bot.api.db.SubscribeKey(EventSubEventKey, func(value string) {
+		// The event expired and was removed
+		if value == "" {
+			return
+		}
 		var ev eventSubNotification
 		err := json.UnmarshalFromString(value, &ev)
 		if err != nil {
@@ -23,7 +23,7 @@ type Bot struct {
 	username    string
 	logger      *zap.Logger
 	lastMessage *sync.RWSync[time.Time]
-	chatHistory *sync.Slice[irc.PrivateMessage]
+	chatLog     *database.EventLog
 
 	commands        *sync.Map[string, BotCommand]
 	customCommands  *sync.Map[string, BotCustomCommand]
@@ -73,12 +73,27 @@ func newBot(api *Client, config BotConfig) *Bot {
 		commands:        sync.NewMap[string, BotCommand](),
 		customCommands:  sync.NewMap[string, BotCustomCommand](),
 		customTemplates: sync.NewMap[string, *template.Template](),
-		chatHistory:     sync.NewSlice[irc.PrivateMessage](),
 
 		OnConnect: utils.NewPubSub[BotConnectHandler](),
 		OnMessage: utils.NewPubSub[BotMessageHandler](),
 	}
 
+	if config.ChatHistory > 0 {
+		var err error
+		bot.chatLog, err = database.NewEventLog(api.db, ChatLogPrefix, database.RetentionPolicy{MaxEntries: config.ChatHistory})
+		if err != nil {
+			bot.logger.Error("could not open chat history, messages won't be saved", zap.Error(err))
+		} else {
+			mirrorSize := config.ChatHistory
+			if mirrorSize > ChatHistoryKeySize {
+				mirrorSize = ChatHistoryKeySize
+			}
+			if err = bot.chatLog.MirrorTo(ChatHistoryKey, mirrorSize); err != nil {
+				bot.logger.Error("could not write chat history", zap.String("key", ChatHistoryKey), zap.Error(err))
+			}
+		}
+	}
+
 	client.OnConnect(func() {
 		for _, handler := range bot.OnConnect.Subscribers() {
 			if handler != nil {
@@ -142,13 +157,8 @@ func newBot(api *Client, config BotConfig) *Bot {
 		if err != nil {
 			bot.logger.Warn("could not save chat message to key", zap.String("key", ChatEventKey), zap.Error(err))
 		}
-		if bot.Config.ChatHistory > 0 {
-			history := bot.chatHistory.Get()
-			if len(history) >= bot.Config.ChatHistory {
-				history = history[len(history)-bot.Config.ChatHistory+1:]
-			}
-			bot.chatHistory.Set(append(history, message))
-			err = bot.api.db.PutJSON(ChatHistoryKey, bot.chatHistory.Get())
+		if bot.chatLog != nil {
+			err = bot.chatLog.AppendJSON(message)
 			if err != nil {
 				bot.logger.Warn("could not save message to chat history", zap.Error(err))
 			}
@@ -240,6 +250,10 @@ func (b *Bot) updateCommands(value string) {
 }
 
 func (b *Bot) handleWriteMessageRPC(value string) {
+	// The key expired and was removed, there's nothing to write
+	if value == "" {
+		return
+	}
 	b.Client.Say(b.Config.Channel, value)
 }
 
@@ -1,6 +1,7 @@
 package twitch
 
 import (
+	"errors"
 	"math/rand"
 	"time"
 
@@ -53,14 +54,18 @@ func SetupTimers(bot *Bot) *BotTimerModule {
 	// Load config from database
 	err := bot.api.db.GetJSON(BotTimersKey, &mod.Config)
 	if err != nil {
-		bot.logger.Debug("config load error", zap.Error(err))
 		mod.Config = BotTimersConfig{
 			Timers: make(map[string]BotTimer),
 		}
-		// Save empty config
-		err = bot.api.db.PutJSON(BotTimersKey, mod.Config)
-		if err != nil {
-			bot.logger.Warn("could not save default config for bot timers", zap.Error(err))
+		if errors.Is(err, database.ErrEmptyKey) {
+			// Save empty config
+			err = bot.api.db.PutJSON(BotTimersKey, mod.Config)
+			if err != nil {
+				bot.logger.Warn("could not save default config for bot timers", zap.Error(err))
+			}
+		} else {
+			// Don't overwrite data we can't read, it might just need a migration
+			bot.logger.Error("could not read bot timer config, using defaults until it's fixed", zap.Error(err))
 		}
 	}
 
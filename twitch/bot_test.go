@@ -0,0 +1,90 @@
+package twitch
+
+import (
+	"bufio"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	irc "github.com/gempir/go-twitch-irc/v3"
+	"go.uber.org/zap"
+
+	"github.com/strimertul/strimertul/database"
+)
+
+func TestWriteMessageRPCIgnoresExpiredKey(t *testing.T) {
+	driver, err := database.NewInMemory(zap.NewNop())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer driver.Close()
+	go driver.Hub().Run()
+	db, err := database.NewLocalClient(driver.Hub(), driver.Secrets(), zap.NewNop())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	// Fake chat server, collecting the messages the bot writes
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	messages := make(chan string, 10)
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		scanner := bufio.NewScanner(conn)
+		for scanner.Scan() {
+			if strings.HasPrefix(scanner.Text(), "PRIVMSG ") {
+				messages <- scanner.Text()
+			}
+		}
+	}()
+
+	bot := &Bot{
+		Client: irc.NewClient("bot", "oauth:test"),
+		Config: BotConfig{Channel: "channel"},
+	}
+	bot.Client.IrcAddress = listener.Addr().String()
+	bot.Client.TLS = false
+	go func() {
+		_ = bot.Client.Connect()
+	}()
+	defer func() {
+		_ = bot.Client.Disconnect()
+	}()
+
+	// The subscription goes away with the client, cancelling it while notifications are delivered races in kilovolt
+	err, _ = db.SubscribeKey(WriteMessageRPC, bot.handleWriteMessageRPC)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err = db.PutKey(WriteMessageRPC, "hello"); err != nil {
+		t.Fatal(err)
+	}
+	// Expired keys are removed like this, subscribers get an empty value
+	if err = db.RemoveKey(WriteMessageRPC); err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case message := <-messages:
+		if message != "PRIVMSG #channel :hello" {
+			t.Fatalf("bot wrote %q, expected the RPC message", message)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("bot didn't write the RPC message")
+	}
+	select {
+	case message := <-messages:
+		t.Fatalf("bot wrote %q after the RPC key expired", message)
+	case <-time.After(200 * time.Millisecond):
+	}
+}
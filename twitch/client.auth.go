@@ -28,7 +28,7 @@ func (c *Client) GetAuthorizationURL() string {
 
 func (c *Client) GetUserClient() (*helix.Client, error) {
 	var authResp AuthResponse
-	err := c.db.GetJSON(AuthKey, &authResp)
+	err := c.db.GetSecretJSON(AuthKey, &authResp)
 	if err != nil {
 		return nil, err
 	}
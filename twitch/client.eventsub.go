@@ -117,18 +117,9 @@ func (c *Client) processEvent(message EventSubWebsocketMessage) {
 		c.logger.Error("error saving event to db", zap.String("key", EventSubEventKey), zap.Error(err))
 	}
 
-	var archive []NotificationMessagePayload
-	err = c.db.GetJSON(EventSubHistoryKey, &archive)
+	err = c.eventLog.AppendJSON(notificationData)
 	if err != nil {
-		archive = []NotificationMessagePayload{}
-	}
-	archive = append(archive, notificationData)
-	if len(archive) > EventSubHistorySize {
-		archive = archive[len(archive)-EventSubHistorySize:]
-	}
-	err = c.db.PutJSON(EventSubHistoryKey, archive)
-	if err != nil {
-		c.logger.Error("error saving event to db", zap.String("key", EventSubHistoryKey), zap.Error(err))
+		c.logger.Error("error saving event to history", zap.String("log", EventSubLogPrefix), zap.Error(err))
 	}
 }
 
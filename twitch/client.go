@@ -26,21 +26,27 @@ type Manager struct {
 func NewManager(db *database.LocalDBClient, server *http.Server, logger *zap.Logger) (*Manager, error) {
 	// Get Twitch Config
 	var config Config
-	if err := db.GetJSON(ConfigKey, &config); err != nil {
+	if err := db.GetSecretJSON(ConfigKey, &config); err != nil {
 		if !errors.Is(err, database.ErrEmptyKey) {
 			return nil, fmt.Errorf("failed to get twitch config: %w", err)
 		}
 		config.Enabled = false
 	}
+	if err := database.ApplyEnvOverrides(ConfigKey, &config); err != nil {
+		return nil, fmt.Errorf("failed to apply environment to twitch config: %w", err)
+	}
 
 	// Get Twitch bot Config
 	var botConfig BotConfig
-	if err := db.GetJSON(BotConfigKey, &botConfig); err != nil {
+	if err := db.GetSecretJSON(BotConfigKey, &botConfig); err != nil {
 		if !errors.Is(err, database.ErrEmptyKey) {
 			return nil, fmt.Errorf("failed to get bot config: %w", err)
 		}
 		config.EnableBot = false
 	}
+	if err := database.ApplyEnvOverrides(BotConfigKey, &botConfig); err != nil {
+		return nil, fmt.Errorf("failed to apply environment to bot config: %w", err)
+	}
 
 	// Create new client
 	client, err := newClient(config, db, server, logger)
@@ -58,9 +64,14 @@ func NewManager(db *database.LocalDBClient, server *http.Server, logger *zap.Log
 	}
 
 	// Listen for client config changes
-	err, cancelConfigSub := db.SubscribeKey(ConfigKey, func(value string) {
+	err, cancelConfigSub := db.SubscribeKey(ConfigKey, func(string) {
+		// The notified value has secrets masked, read it again with secrets
 		var newConfig Config
-		if err := json.UnmarshalFromString(value, &newConfig); err != nil {
+		err := db.GetSecretJSON(ConfigKey, &newConfig)
+		if err == nil {
+			err = database.ApplyEnvOverrides(ConfigKey, &newConfig)
+		}
+		if err != nil {
 			logger.Error("failed to unmarshal config", zap.Error(err))
 			return
 		}
@@ -88,9 +99,14 @@ func NewManager(db *database.LocalDBClient, server *http.Server, logger *zap.Log
 	}
 
 	// Listen for bot config changes
-	err, cancelBotSub := db.SubscribeKey(BotConfigKey, func(value string) {
+	err, cancelBotSub := db.SubscribeKey(BotConfigKey, func(string) {
+		// The notified value has secrets masked, read it again with secrets
 		var newBotConfig BotConfig
-		if err := json.UnmarshalFromString(value, &newBotConfig); err != nil {
+		err := db.GetSecretJSON(BotConfigKey, &newBotConfig)
+		if err == nil {
+			err = database.ApplyEnvOverrides(BotConfigKey, &newBotConfig)
+		}
+		if err != nil {
 			logger.Error("failed to unmarshal Config", zap.Error(err))
 			return
 		}
@@ -149,6 +165,7 @@ type Client struct {
 	API        *helix.Client
 	logger     *zap.Logger
 	eventCache *lru.Cache
+	eventLog   *database.EventLog
 	server     *http.Server
 	ctx        context.Context
 	cancel     context.CancelFunc
@@ -178,6 +195,14 @@ func newClient(config Config, db *database.LocalDBClient, server *http.Server, l
 		return nil, fmt.Errorf("could not create LRU cache for events: %w", err)
 	}
 
+	eventLog, err := database.NewEventLog(db, EventSubLogPrefix, database.RetentionPolicy{MaxEntries: EventSubHistorySize})
+	if err != nil {
+		return nil, fmt.Errorf("could not open event history: %w", err)
+	}
+	if err = eventLog.MirrorTo(EventSubHistoryKey, EventSubHistoryKeySize); err != nil {
+		return nil, fmt.Errorf("could not write event history: %w", err)
+	}
+
 	// Create Twitch client
 	ctx, cancel := context.WithCancel(context.Background())
 	client := &Client{
@@ -187,6 +212,7 @@ func newClient(config Config, db *database.LocalDBClient, server *http.Server, l
 		restart:            make(chan bool, 128),
 		streamOnline:       sync.NewRWSync(false),
 		eventCache:         eventCache,
+		eventLog:           eventLog,
 		savedSubscriptions: make(map[string]bool),
 		ctx:                ctx,
 		cancel:             cancel,
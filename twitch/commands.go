@@ -3,7 +3,6 @@ package twitch
 import (
 	"bytes"
 	"math/rand"
-	"strconv"
 	"strings"
 	"text/template"
 
@@ -86,16 +85,11 @@ func (b *Bot) setupFunctions() {
 		},
 		"count": func(name string) int {
 			counterKey := BotCounterPrefix + name
-			counter := 0
-			if byt, err := b.api.db.GetKey(counterKey); err == nil {
-				counter, _ = strconv.Atoi(byt)
-			}
-			counter += 1
-			err := b.api.db.PutKey(counterKey, strconv.Itoa(counter))
+			counter, err := b.api.db.Increment(counterKey, 1)
 			if err != nil {
 				b.logger.Error("error saving key", zap.Error(err), zap.String("key", counterKey))
 			}
-			return counter
+			return int(counter)
 		},
 	}
 }
@@ -1,5 +1,12 @@
 package twitch
 
+import (
+	irc "github.com/gempir/go-twitch-irc/v3"
+	"github.com/nicklaw5/helix/v2"
+
+	"github.com/strimertul/strimertul/database"
+)
+
 const CallbackRoute = "/twitch/callback"
 
 const ConfigKey = "twitch/config"
@@ -24,10 +31,16 @@ type BotConfig struct {
 
 const (
 	ChatEventKey    = "twitch/ev/chat-message"
-	ChatHistoryKey  = "twitch/chat-history"
+	ChatLogPrefix   = "twitch/chat-log/"
 	ChatActivityKey = "twitch/chat-activity"
 )
 
+// ChatHistoryKey holds the last chat messages as a single array, for overlays written before the log in
+// ChatLogPrefix. It's kept at ChatHistoryKeySize messages at most, read the log for more.
+const ChatHistoryKey = "twitch/chat-history"
+
+const ChatHistoryKeySize = 100
+
 type BotCustomCommand struct {
 	Description string          `json:"description"`
 	AccessLevel AccessLevelType `json:"access_level"`
@@ -43,9 +56,61 @@ const BotCounterPrefix = "twitch/bot-counters/"
 
 const AuthKey = "twitch/auth-keys"
 
+func init() {
+	// Keep credentials out of exports
+	database.RegisterSecret(ConfigKey, "api_client_secret")
+	database.RegisterSecret(BotConfigKey, "oauth")
+	database.RegisterSecret(AuthKey, "access_token", "refresh_token")
+
+	// Let containers inject credentials without storing them
+	database.RegisterEnvOverride(
+		database.EnvOverride{Key: ConfigKey, Field: "api_client_id", Variable: "STRIMERTUL_TWITCH_CLIENT_ID"},
+		database.EnvOverride{Key: ConfigKey, Field: "api_client_secret", Variable: "STRIMERTUL_TWITCH_CLIENT_SECRET"},
+		database.EnvOverride{Key: BotConfigKey, Field: "oauth", Variable: "STRIMERTUL_BOT_OAUTH_TOKEN"},
+	)
+
+	// Events and RPC calls don't need to stick around
+	database.RegisterTTL("twitch/ev/", database.EventTTL)
+	database.RegisterTTL(WriteMessageRPC, database.RPCTTL)
+
+	// Let the database check know what these keys hold
+	for key, value := range map[string]interface{}{
+		ConfigKey:          Config{},
+		BotConfigKey:       BotConfig{},
+		AuthKey:            AuthResponse{},
+		StreamInfoKey:      []helix.Stream{},
+		ChatEventKey:       irc.PrivateMessage{},
+		ChatLogPrefix:      irc.PrivateMessage{},
+		ChatActivityKey:    []int{},
+		CustomCommandsKey:  map[string]BotCustomCommand{},
+		WriteMessageRPC:    nil,
+		BotCounterPrefix:   int64(0),
+		BotTimersKey:       BotTimersConfig{},
+		BotAlertsKey:       BotAlertsConfig{},
+		EventSubEventKey:   NotificationMessagePayload{},
+		EventSubLogPrefix:  NotificationMessagePayload{},
+		ChatHistoryKey:     []irc.PrivateMessage{},
+		EventSubHistoryKey: []NotificationMessagePayload{},
+	} {
+		database.RegisterSchema(database.KeySchema{Key: key, Value: value})
+	}
+
+	database.RegisterMigration(database.Migration{
+		Version:     1,
+		Description: "move chat and event history to event logs",
+		Migrate:     migrateHistoryToLogs,
+	})
+}
+
 const (
-	EventSubEventKey   = "twitch/ev/eventsub-event"
-	EventSubHistoryKey = "twitch/eventsub-history"
+	EventSubEventKey  = "twitch/ev/eventsub-event"
+	EventSubLogPrefix = "twitch/eventsub-log/"
 )
 
-const EventSubHistorySize = 100
+// EventSubHistoryKey holds the last events as a single array, for overlays written before the log in
+// EventSubLogPrefix. It's kept at EventSubHistoryKeySize events at most, read the log for more.
+const EventSubHistoryKey = "twitch/eventsub-history"
+
+const EventSubHistoryKeySize = 100
+
+const EventSubHistorySize = 1000
@@ -0,0 +1,94 @@
+package twitch
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	jsoniter "github.com/json-iterator/go"
+
+	"github.com/strimertul/strimertul/database"
+)
+
+// migrateHistoryToLogs copies the chat and event history arrays to their event logs, oldest first.
+// The arrays are left in place, they are kept up to date with the logs from now on.
+func migrateHistoryToLogs(db *database.LocalDBClient) error {
+	for key, prefix := range map[string]string{
+		ChatHistoryKey:     ChatLogPrefix,
+		EventSubHistoryKey: EventSubLogPrefix,
+	} {
+		var history []jsoniter.RawMessage
+		err := db.GetJSON(key, &history)
+		if err != nil {
+			if errors.Is(err, database.ErrEmptyKey) {
+				continue
+			}
+			return fmt.Errorf("could not read %s: %w", key, err)
+		}
+
+		// Size limits are applied once the logs are opened
+		log, err := database.NewEventLog(db, prefix, database.RetentionPolicy{})
+		if err != nil {
+			return err
+		}
+		for index, at := range historyTimes(history) {
+			if _, err = log.AppendAt(at, string(history[index])); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// eventTimeFields are the EventSub event fields that say when the event happened, most relevant first
+// (eg. poll end events have both started_at and ended_at)
+var eventTimeFields = []string{"followed_at", "redeemed_at", "ended_at", "locked_at", "started_at"}
+
+// historyEntryTime returns when a chat message or event of the old history arrays happened, if it says so
+func historyEntryTime(entry jsoniter.RawMessage) (time.Time, bool) {
+	var payload struct {
+		Time  time.Time                      `json:"Time"`  // Chat messages (irc.PrivateMessage)
+		Event map[string]jsoniter.RawMessage `json:"event"` // EventSub notifications
+	}
+	if err := json.Unmarshal(entry, &payload); err != nil {
+		return time.Time{}, false
+	}
+	if !payload.Time.IsZero() {
+		return payload.Time, true
+	}
+	for _, field := range eventTimeFields {
+		var at time.Time
+		if raw, ok := payload.Event[field]; ok && json.Unmarshal(raw, &at) == nil && !at.IsZero() {
+			return at, true
+		}
+	}
+	return time.Time{}, false
+}
+
+// historyTimes returns when each entry of a history array happened. Entries that don't say get the time of the
+// entry before them (or after them, for the first ones), so the history keeps its order.
+func historyTimes(history []jsoniter.RawMessage) []time.Time {
+	times := make([]time.Time, len(history))
+	var last time.Time
+	for index, entry := range history {
+		if at, ok := historyEntryTime(entry); ok {
+			last = at
+		}
+		times[index] = last
+	}
+	// Fill the first entries, if nothing has a time it all happened now as far as we know
+	first := time.Now()
+	for _, at := range times {
+		if !at.IsZero() {
+			first = at
+			break
+		}
+	}
+	for index := range times {
+		if !times[index].IsZero() {
+			break
+		}
+		times[index] = first
+	}
+	return times
+}
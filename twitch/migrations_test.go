@@ -0,0 +1,146 @@
+package twitch
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	irc "github.com/gempir/go-twitch-irc/v3"
+	"go.uber.org/zap"
+
+	"github.com/strimertul/strimertul/database"
+)
+
+func TestMigrateHistoryToLogs(t *testing.T) {
+	driver, err := database.NewInMemory(zap.NewNop())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer driver.Close()
+	go driver.Hub().Run()
+	db, err := database.NewLocalClient(driver.Hub(), driver.Secrets(), zap.NewNop())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	// Only chat has history, the event log must not be created out of nothing
+	if err = db.PutKey(ChatHistoryKey, `[{"message":"first"},{"message":"second"},{"message":"third"}]`); err != nil {
+		t.Fatal(err)
+	}
+	if err = migrateHistoryToLogs(db); err != nil {
+		t.Fatalf("migration failed: %s", err)
+	}
+
+	tests := []struct {
+		prefix   string
+		expected []string
+	}{
+		{ChatLogPrefix, []string{`{"message":"first"}`, `{"message":"second"}`, `{"message":"third"}`}},
+		{EventSubLogPrefix, nil},
+	}
+	for _, test := range tests {
+		t.Run(test.prefix, func(t *testing.T) {
+			log, err := database.NewEventLog(db, test.prefix, database.RetentionPolicy{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			entries, err := log.Tail(10)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(entries) != len(test.expected) {
+				t.Fatalf("log has %d entries, expected %d", len(entries), len(test.expected))
+			}
+			for index, entry := range entries {
+				if entry.Data != test.expected[index] {
+					t.Fatalf("entry %d is %s, expected %s", index, entry.Data, test.expected[index])
+				}
+			}
+		})
+	}
+
+	// Overlays reading the old key must still find the history there
+	history, err := db.GetKey(ChatHistoryKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if history == "" {
+		t.Fatalf("history was removed from %s", ChatHistoryKey)
+	}
+}
+
+func TestMigrateHistoryKeepsTimes(t *testing.T) {
+	driver, err := database.NewInMemory(zap.NewNop())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer driver.Close()
+	go driver.Hub().Run()
+	db, err := database.NewLocalClient(driver.Hub(), driver.Secrets(), zap.NewNop())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	day := time.Date(2023, 5, 1, 0, 0, 0, 0, time.UTC)
+	chat := []irc.PrivateMessage{
+		{Message: "morning", Time: day.Add(9 * time.Hour)},
+		{Message: "evening", Time: day.Add(21 * time.Hour)},
+	}
+	if err = db.PutJSON(ChatHistoryKey, chat); err != nil {
+		t.Fatal(err)
+	}
+	events := `[{"subscription":{"type":"channel.subscribe"},"event":{"user_name":"first"}},` +
+		`{"subscription":{"type":"channel.follow"},"event":{"followed_at":"2023-05-01T10:00:00Z"}},` +
+		`{"subscription":{"type":"channel.subscribe"},"event":{"user_name":"after"}},` +
+		`{"subscription":{"type":"channel.poll.end"},"event":{"started_at":"2023-05-01T11:00:00Z","ended_at":"2023-05-01T12:00:00Z"}}]`
+	if err = db.PutKey(EventSubHistoryKey, events); err != nil {
+		t.Fatal(err)
+	}
+	if err = migrateHistoryToLogs(db); err != nil {
+		t.Fatalf("migration failed: %s", err)
+	}
+
+	tests := []struct {
+		prefix   string
+		expected []time.Time
+	}{
+		{ChatLogPrefix, []time.Time{day.Add(9 * time.Hour), day.Add(21 * time.Hour)}},
+		// Events without a time take the one of the closest event before them (or after, for the first ones)
+		{EventSubLogPrefix, []time.Time{day.Add(10 * time.Hour), day.Add(10 * time.Hour), day.Add(10 * time.Hour), day.Add(12 * time.Hour)}},
+	}
+	for _, test := range tests {
+		t.Run(test.prefix, func(t *testing.T) {
+			log, err := database.NewEventLog(db, test.prefix, database.RetentionPolicy{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			entries, err := log.Tail(10)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(entries) != len(test.expected) {
+				t.Fatalf("log has %d entries, expected %d", len(entries), len(test.expected))
+			}
+			for index, entry := range entries {
+				if !entry.Time.Equal(test.expected[index]) {
+					t.Fatalf("entry %d is at %s, expected %s", index, entry.Time.UTC(), test.expected[index])
+				}
+			}
+		})
+	}
+
+	// Looking up the morning only finds what happened then
+	log, err := database.NewEventLog(db, ChatLogPrefix, database.RetentionPolicy{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	entries, err := log.Range(day, day.Add(12*time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 || !strings.Contains(entries[0].Data, "morning") {
+		t.Fatalf("unexpected entries in range: %v", entries)
+	}
+}